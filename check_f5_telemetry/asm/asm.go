@@ -0,0 +1,268 @@
+package asm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The state reported when all ASM policies are applied
+const PoliciesConsistent = "Policies Consistent"
+
+// The Asm object created and initialized by NewAsm consolidates the
+// connection to Elasticsearch, the nagios object, the index name and the
+// counters needed to run the check.
+type Asm struct {
+	index      string
+	counters   []string
+	connection *elasticsearch.Elasticsearch
+	nagios     *nagiosplugin.Check
+}
+
+// Consolidated ASM state
+type AsmState struct {
+	Timestamp        time.Time
+	State            string
+	LastChange       time.Time
+	Signatures       map[string]time.Time
+	LatestSignatures time.Time
+	Counters         map[string]float64
+}
+
+// Creates an Asm object containing the connection object to Elasticsearch, a
+// Nagios object, the Index and the names of the counter fields (e.g.
+// blocking counters) which should be checked against thresholds
+func NewAsm(Index string, Counters []string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Asm, error) {
+	var a *Asm
+
+	logger := log.With().Str("func", "NewAsm").Str("package", "asm").Logger()
+	logger.Trace().Msg("Enter func")
+	a = new(Asm)
+	a.index = Index
+	a.counters = Counters
+	a.connection = Connection
+	a.nagios = Nagios
+	return a, nil
+}
+
+// Execute the query
+func (a *Asm) Execute() (*AsmState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "asm").Logger()
+	logger.Trace().Msg("Enter func")
+	fields := []string{"\"@timestamp\"", "\"system.asmState*\"", "\"system.lastAsmChange*\"", "\"system.asmAttackSignatures.*\""}
+	for _, c := range a.counters {
+		f, err := json.Marshal(c)
+		if err != nil {
+			logger.Error().Str("id", "ERR40020002").Str("counter", c).Err(err).Msg("Could not encode counter name")
+			a.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not encode counter name %v", c))
+			return nil, err
+		}
+		fields = append(fields, string(f))
+	}
+	q := "{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + a.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[" + strings.Join(fields, ",") + "],\"_source\":false}"
+	data, err := a.connection.Search(a.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR40020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		a.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, a.index, q))
+		return nil, err
+	}
+	return a.gatherAsmState(data)
+}
+
+// Convert the latest document with the ASM state, see Execute
+func (a *Asm) Gather(e *elasticsearch.ElasticsearchResult) (*AsmState, error) {
+	return a.gatherAsmState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (a *Asm) gatherAsmState(e *elasticsearch.ElasticsearchResult) (*AsmState, error) {
+	var s *AsmState
+	var fields elasticsearch.HitElement
+	logger := log.With().Str("func", "gatherAsmState").Str("package", "asm").Logger()
+	logger.Trace().Msg("Enter func")
+	if len(e.Hits.Hits) == 0 {
+		fields = make(elasticsearch.HitElement)
+	} else {
+		fields = e.Hits.Hits[0].Fields
+	}
+
+	if len(fields) == 0 {
+		a.nagios.AddResult(nagiosplugin.UNKNOWN, "No data for ASM check")
+		logger.Error().Str("id", "ERR40030001").Msg("No data for ASM check")
+		return nil, errors.New("No data for ASM check")
+	}
+	s = new(AsmState)
+	s.State = stringField(fields, "system.asmState")
+	if s.State == "" {
+		a.nagios.AddResult(nagiosplugin.UNKNOWN, "No asmState in telemetry data. Is ASM provisioned?")
+		logger.Error().Str("id", "ERR40030002").Str("field", "system.asmState").Msg("No asmState in telemetry data")
+		return nil, errors.New("No asmState in telemetry data")
+	}
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		a.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR40030003").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, err
+	}
+	s.Timestamp = ts
+	if v, ok := fields["system.lastAsmChange"].([]interface{}); ok && len(v) > 0 {
+		if s.LastChange, err = parseTime(v[0]); err != nil {
+			logger.Warn().Str("id", "WRN40030001").
+				Str("field", "system.lastAsmChange").
+				Str("value", fmt.Sprintf("%v", v[0])).
+				Err(err).
+				Msg("Could not parse last ASM change")
+		}
+	}
+
+	// The update time is preferred, older TS versions only report the
+	// creation time of the signature file
+	s.Signatures = make(map[string]time.Time)
+	prefix := "system.asmAttackSignatures."
+	for _, suffix := range []string{".createDateTime", ".updateDateTime"} {
+		for f := range fields {
+			if !strings.HasPrefix(f, prefix) || !strings.HasSuffix(f, suffix) {
+				continue
+			}
+			name := strings.TrimSuffix(strings.TrimPrefix(f, prefix), suffix)
+			v := fields[f].([]interface{})[0]
+			updated, err := parseTime(v)
+			if err != nil {
+				logger.Warn().Str("id", "WRN40030002").
+					Str("field", f).
+					Str("value", fmt.Sprintf("%v", v)).
+					Err(err).
+					Msg("Could not parse signature update time")
+				continue
+			}
+			logger.Debug().Str("id", "DBG40030001").
+				Str("signatures", name).
+				Str("field", f).
+				Time("updated", updated).
+				Msg("Found attack signatures")
+			s.Signatures[name] = updated
+		}
+	}
+	for _, updated := range s.Signatures {
+		if updated.After(s.LatestSignatures) {
+			s.LatestSignatures = updated
+		}
+	}
+
+	s.Counters = make(map[string]float64)
+	for _, c := range a.counters {
+		if v, ok := fields[c].([]interface{}); ok && len(v) > 0 {
+			if n, ok := v[0].(float64); ok {
+				s.Counters[c] = n
+				continue
+			}
+		}
+		logger.Warn().Str("id", "WRN40030003").Str("field", c).Msg("Counter is missing")
+	}
+	return s, nil
+}
+
+// Parse a time reported either as RFC3339 string or as milliseconds since the
+// epoch, which TS uses for the attack signature times.
+func parseTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case float64:
+		return time.UnixMilli(int64(t)).UTC(), nil
+	case string:
+		if ms, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return time.UnixMilli(ms).UTC(), nil
+		}
+		return time.Parse(time.RFC3339, t)
+	}
+	return time.Time{}, fmt.Errorf("unsupported time value %v", v)
+}
+
+// Get a string field, preferring the keyword variant. Returns an empty string
+// if the field doesn't exist.
+func stringField(fields elasticsearch.HitElement, fieldname string) string {
+	for _, f := range []string{fieldname + ".keyword", fieldname} {
+		if v, ok := fields[f].([]interface{}); ok && len(v) > 0 {
+			return fmt.Sprintf("%v", v[0])
+		}
+	}
+	return ""
+}
+
+// Check the ASM state. The policies must be consistent, the newest attack
+// signatures must not be older than SignatureWarn and SignatureCrit (zero
+// disables the check) and the counters must be within their thresholds.
+func (a *Asm) Check(s *AsmState, SignatureWarn time.Duration, SignatureCrit time.Duration, Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "asm").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if s.State != PoliciesConsistent {
+		msg := fmt.Sprintf("WARNING: ASM policies are not applied, state is %v", s.State)
+		if !s.LastChange.IsZero() {
+			msg += fmt.Sprintf(", last change %v", s.LastChange.Format(time.RFC3339))
+		}
+		a.nagios.AddResult(nagiosplugin.WARNING, msg)
+	} else {
+		a.nagios.AddResult(nagiosplugin.OK, "OK: ASM "+s.State)
+	}
+
+	if SignatureWarn > 0 || SignatureCrit > 0 {
+		if s.LatestSignatures.IsZero() {
+			a.nagios.AddResult(nagiosplugin.UNKNOWN, "UNKNOWN: No attack signature update time in telemetry data")
+		} else {
+			age := s.Timestamp.Sub(s.LatestSignatures)
+			msg := fmt.Sprintf("attack signatures from %v are %v old", s.LatestSignatures.Format(time.RFC3339), age.Round(time.Hour))
+			logger.Debug().Str("id", "DBG40050001").Dur("age", age).Msg("Attack signature age")
+			if SignatureCrit > 0 && age > SignatureCrit {
+				a.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: "+msg)
+			} else if SignatureWarn > 0 && age > SignatureWarn {
+				a.nagios.AddResult(nagiosplugin.WARNING, "WARNING: "+msg)
+			} else {
+				a.nagios.AddResult(nagiosplugin.OK, "OK: "+msg)
+			}
+		}
+	}
+
+	for _, c := range a.counters {
+		v, found := s.Counters[c]
+		if !found {
+			a.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: Counter %v is missing in the telemetry data", c))
+			continue
+		}
+		th := Thresholds[c]
+		if threshold.CheckRange(a.nagios, th.Critical, v, "critical") {
+			a.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v %v is outside the critical range %v", c, v, th.Critical))
+		} else if threshold.CheckRange(a.nagios, th.Warning, v, "warning") {
+			a.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v %v is outside the warning range %v", c, v, th.Warning))
+		}
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(v)
+		a.nagios.AddPerfDatum(c, "c", p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+	}
+	if !s.LatestSignatures.IsZero() {
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(s.Timestamp.Sub(s.LatestSignatures).Seconds())
+		a.nagios.AddPerfDatum("signature_age", "s", p, nil, nil, nil, nil)
+	}
+}
+
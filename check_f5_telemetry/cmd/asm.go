@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/asm"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "asm" checks the ASM policy state, the age of the attack
+// signatures and optional counters
+var asmCmd = &cobra.Command{
+	Use:   "asm",
+	Short: "Check ASM",
+	Long:  `Check F5 ASM policy state and attack signatures in telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var a *asm.Asm
+
+		logger := log.With().Str("func", "asm.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020004").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+		var signatureWarn, signatureCrit time.Duration
+		if viper.GetString("signature-age-warning") != "" {
+			if signatureWarn, err = units.ParseDuration(viper.GetString("signature-age-warning")); err != nil {
+				logger.Error().Str("id", "00010008").Err(err).Msg("Could not parse signature age warning")
+				nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse signature age warning")
+				return
+			}
+		}
+		if viper.GetString("signature-age-critical") != "" {
+			if signatureCrit, err = units.ParseDuration(viper.GetString("signature-age-critical")); err != nil {
+				logger.Error().Str("id", "00010009").Err(err).Msg("Could not parse signature age critical")
+				nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse signature age critical")
+				return
+			}
+		}
+		thresholds, err := threshold.Merge("", "", nil, nil, viper.GetStringSlice("threshold"), func(Name string) (string, bool) { return Name, true })
+		if err != nil {
+			logger.Error().Str("id", "00010010").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+		counters := make([]string, 0, len(thresholds))
+		for c := range thresholds {
+			counters = append(counters, c)
+		}
+		sort.Strings(counters)
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010063").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		a, err = asm.NewAsm(viper.GetString("index"), counters, elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create asm check: "+err.Error())
+			logger.Error().Str("id", "00010059").Err(err).Msg("Could not create asm check")
+			return
+		}
+		result, err := a.Execute()
+		if err != nil {
+			return
+		}
+		a.Check(result, signatureWarn, signatureCrit, thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
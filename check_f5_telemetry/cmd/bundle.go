@@ -0,0 +1,560 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/asm"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/connections"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/gtm"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/interfaces"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/irule"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/pool"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/profile"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/system"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/throughput"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The query for the two latest documents shared by all checks of a bundle,
+// the placeholder takes the query clause restricting it to the device. Two
+// documents are needed for the checks calculating rates from counters.
+const bundleQuery = "{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":%v,\"fields\":[\"*\"],\"_source\":false}"
+
+// A named check in the bundle section of the configuration file. Type is the
+// name of the subcommand, the other keys are named like the command line
+// flags of that subcommand.
+type bundleCheck struct {
+	Name                 string                         `mapstructure:"name"`
+	Type                 string                         `mapstructure:"type"`
+	Service              string                         `mapstructure:"service"`
+	Warning              string                         `mapstructure:"warning"`
+	Critical             string                         `mapstructure:"critical"`
+	Thresholds           map[string]threshold.Threshold `mapstructure:"thresholds"`
+	Threshold            []string                       `mapstructure:"threshold"`
+	Pool                 string                         `mapstructure:"pool"`
+	IgnoreDisabled       bool                           `mapstructure:"ignore-disabled"`
+	MemberInclude        string                         `mapstructure:"member-include"`
+	MemberExclude        string                         `mapstructure:"member-exclude"`
+	MemberSeverity       string                         `mapstructure:"member-severity"`
+	MemberProblemsOnly   bool                           `mapstructure:"member-problems-only"`
+	MemberPerfdata       bool                           `mapstructure:"member-perfdata"`
+	ConnectionLimit      float64                        `mapstructure:"connection-limit"`
+	UtilizationWarning   string                         `mapstructure:"utilization-warning"`
+	UtilizationCritical  string                         `mapstructure:"utilization-critical"`
+	ImbalanceMetric      string                         `mapstructure:"imbalance-metric"`
+	ImbalanceWarning     string                         `mapstructure:"imbalance-warning"`
+	ImbalanceCritical    string                         `mapstructure:"imbalance-critical"`
+	ImbalanceIdleMean    float64                        `mapstructure:"imbalance-idle-mean"`
+	Window               string                         `mapstructure:"window"`
+	UnavailableWarning   string                         `mapstructure:"unavailable-warning"`
+	UnavailableCritical  string                         `mapstructure:"unavailable-critical"`
+	FlapThreshold        int                            `mapstructure:"flap-threshold"`
+	MembershipState      string                         `mapstructure:"membership-state"`
+	MembershipAge        string                         `mapstructure:"membership-age"`
+	InterfaceInclude     string                         `mapstructure:"interface-include"`
+	InterfaceExclude     string                         `mapstructure:"interface-exclude"`
+	IRuleInclude         string                         `mapstructure:"irule-include"`
+	ProfileType          string                         `mapstructure:"profile-type"`
+	ProfileName          string                         `mapstructure:"profile-name"`
+	GtmType              string                         `mapstructure:"gtm-type"`
+	RecordType           string                         `mapstructure:"record-type"`
+	GtmName              string                         `mapstructure:"gtm-name"`
+	Provisioning         []string                       `mapstructure:"provisioning"`
+	AllowedVersions      []string                       `mapstructure:"allowed-versions"`
+	SignatureAgeWarning  string                         `mapstructure:"signature-age-warning"`
+	SignatureAgeCritical string                         `mapstructure:"signature-age-critical"`
+}
+
+// The check types supported by the bundle
+var bundleTypes = map[string]func(bundleCheck, *elasticsearch.ElasticsearchResult, *maintenance.Schedule, *elasticsearch.Elasticsearch, *nagiosplugin.Check) error{
+	"pool":          runBundlePool,
+	"throughput":    runBundleThroughput,
+	"connections":   runBundleConnections,
+	"system-status": runBundleSystem,
+	"interfaces":    runBundleInterfaces,
+	"irule":         runBundleIRule,
+	"profile":       runBundleProfile,
+	"gtm":           runBundleGtm,
+	"asm":           runBundleAsm,
+}
+
+// The result of a single check of the bundle
+type bundleResult struct {
+	check  bundleCheck
+	status nagiosplugin.Status
+	output string
+}
+
+// The subcommand "bundle" runs all checks from the configuration file against
+// the same document.
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Run the checks from the configuration file",
+	Long:  `Fetch the latest telemetry document once and run all checks defined in the bundle section of the configuration file against it`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.With().Str("func", "bundle.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020011").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		checks, err := loadBundle()
+		if err != nil {
+			logger.Error().Str("id", "00010044").Err(err).Msg("Invalid bundle")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Invalid bundle: "+err.Error())
+			return
+		}
+		if viper.GetString("passive-file") != "" && viper.GetString("device") == "" {
+			logger.Error().Str("id", "00010045").Msg("Passive results need a device")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Passive results need the host name passed as --device")
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010046").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		q := fmt.Sprintf(bundleQuery, elasticsearch.Filter("{\"match_all\":{}}"))
+		data, err := elasticsearch.Search(viper.GetString("index"), q)
+		if err != nil {
+			reason := ""
+			if data != nil {
+				reason = data.Error.Reason
+			}
+			logger.Error().Str("id", "00010047").
+				Str("query", q).
+				Str("reason", reason).
+				Err(err).
+				Msg("Could not run search")
+			nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v", err, viper.GetString("index")))
+			return
+		}
+
+		results := make([]bundleResult, 0, len(checks))
+		for _, c := range checks {
+			results = append(results, runBundleCheck(c, data, schedule, elasticsearch))
+		}
+		if viper.GetString("passive-file") != "" {
+			if err = writePassiveResults(viper.GetString("passive-file"), viper.GetString("device"), results); err != nil {
+				logger.Error().Str("id", "00010048").Str("file", viper.GetString("passive-file")).Err(err).Msg("Could not write passive results")
+				nagios.AddResult(nagiosplugin.UNKNOWN, "Could not write passive results: "+err.Error())
+				return
+			}
+		}
+		log.Info().Msg("Check finished successfully")
+		status, output := aggregateResults(results)
+		fmt.Println(output)
+		os.Exit(int(status))
+	},
+}
+
+// Load and validate the checks from the bundle section of the configuration
+// file
+func loadBundle() ([]bundleCheck, error) {
+	var checks []bundleCheck
+	if err := viper.UnmarshalKey("bundle", &checks); err != nil {
+		return nil, err
+	}
+	if len(checks) == 0 {
+		return nil, errors.New("No checks defined in the bundle section of the configuration file")
+	}
+	names := make(map[string]bool)
+	for _, c := range checks {
+		if c.Name == "" {
+			return nil, errors.New("Every check in the bundle needs a name")
+		}
+		if names[c.Name] {
+			return nil, errors.New("Duplicate check name " + c.Name)
+		}
+		names[c.Name] = true
+		if _, ok := bundleTypes[c.Type]; !ok {
+			return nil, fmt.Errorf("Unknown type %v of check %v", c.Type, c.Name)
+		}
+	}
+	return checks, nil
+}
+
+// Run a single check of the bundle with its own nagios object
+func runBundleCheck(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch) bundleResult {
+	logger := log.With().Str("func", "runBundleCheck").Str("package", "cmd").Str("check", c.Name).Logger()
+	logger.Trace().Msg("Enter func")
+
+	nagios := nagiosplugin.NewCheck()
+	nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+	if err := bundleTypes[c.Type](c, data, schedule, connection, nagios); err != nil {
+		logger.Error().Str("id", "00010049").Str("type", c.Type).Err(err).Msg("Check failed")
+		nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+	}
+	status, output, _ := checkOutput(nagios, schedule, maintenance.Scope{Device: viper.GetString("device"), Pool: c.Pool})
+	return bundleResult{check: c, status: status, output: output}
+}
+
+// Thresholds of a check, merged from the thresholds and threshold keys like
+// the subcommands merge the configuration file and the command line
+func (c bundleCheck) thresholds(Merge func(string, string, map[string]threshold.Threshold, []string) (threshold.Thresholds, error)) (threshold.Thresholds, error) {
+	return Merge(c.Warning, c.Critical, c.Thresholds, c.Threshold)
+}
+
+// Run a pool check, see the pool subcommand
+func runBundlePool(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	if c.Pool == "" {
+		return errors.New("Pool not specified")
+	}
+	if c.MemberSeverity == "" {
+		c.MemberSeverity = "warning"
+	}
+	severity, err := pool.ParseSeverity(c.MemberSeverity)
+	if err != nil {
+		return err
+	}
+	if c.ImbalanceMetric != "" {
+		if err = pool.ValidateImbalanceMetric(c.ImbalanceMetric); err != nil {
+			return err
+		}
+	}
+	window, err := parseWindow(c.Window)
+	if err != nil {
+		return err
+	}
+	var membershipAge time.Duration
+	if c.MembershipAge != "" {
+		if membershipAge, err = units.ParseDuration(c.MembershipAge); err != nil {
+			return err
+		}
+		if membershipAge <= 0 {
+			return errors.New("The membership age must be positive")
+		}
+	}
+	members := pool.MemberOptions{
+		Include:      c.MemberInclude,
+		Exclude:      c.MemberExclude,
+		Severity:     severity,
+		ProblemsOnly: c.MemberProblemsOnly,
+		Perfdata:     c.MemberPerfdata,
+		Maintenance: func(Member string) string {
+			return schedule.Annotation(maintenance.Scope{
+				Device: viper.GetString("device"),
+				Pool:   c.Pool,
+				Member: Member,
+			}, time.Now())
+		},
+	}
+	p, err := pool.NewPool(viper.GetString("index"), c.Pool, c.IgnoreDisabled, members, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := p.Gather(data)
+	if err != nil {
+		return nil
+	}
+	p.Check(result, c.Warning, c.Critical, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	runPoolChecks(p, result, poolChecks{
+		connectionLimit:     c.ConnectionLimit,
+		utilizationWarning:  c.UtilizationWarning,
+		utilizationCritical: c.UtilizationCritical,
+		imbalanceMetric:     c.ImbalanceMetric,
+		imbalanceWarning:    c.ImbalanceWarning,
+		imbalanceCritical:   c.ImbalanceCritical,
+		imbalanceIdleMean:   c.ImbalanceIdleMean,
+		window:              window,
+		unavailableWarning:  c.UnavailableWarning,
+		unavailableCritical: c.UnavailableCritical,
+		flapThreshold:       c.FlapThreshold,
+		membershipState:     c.MembershipState,
+		membershipAge:       membershipAge,
+	})
+	return nil
+}
+
+// Run a throughput check, see the throughput subcommand
+func runBundleThroughput(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	thresholds, err := c.thresholds(throughput.MergeThresholds)
+	if err != nil {
+		return err
+	}
+	t, err := throughput.NewThroughput(viper.GetString("index"), connection, nagios)
+	if err != nil {
+		return err
+	}
+	if err = t.Gather(data); err != nil {
+		return nil
+	}
+	t.Check(thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run a connections check, see the connections subcommand
+func runBundleConnections(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	thresholds, err := c.thresholds(connections.MergeThresholds)
+	if err != nil {
+		return err
+	}
+	conns, err := connections.NewConnections(viper.GetString("index"), connection, nagios)
+	if err != nil {
+		return err
+	}
+	if err = conns.Gather(data); err != nil {
+		return nil
+	}
+	conns.Check(thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run a system status check, see the system-status subcommand
+func runBundleSystem(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	s, err := system.NewSystem(viper.GetString("index"), c.Provisioning, c.AllowedVersions, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := s.Gather(data)
+	if err != nil {
+		return nil
+	}
+	s.Check(result, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run an interfaces check, see the interfaces subcommand
+func runBundleInterfaces(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	thresholds, err := c.thresholds(interfaces.MergeThresholds)
+	if err != nil {
+		return err
+	}
+	i, err := interfaces.NewInterfaces(viper.GetString("index"), c.InterfaceInclude, c.InterfaceExclude, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := i.Gather(data)
+	if err != nil {
+		return nil
+	}
+	i.Check(result, thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run an iRule check, see the irule subcommand
+func runBundleIRule(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	thresholds, err := c.thresholds(irule.MergeThresholds)
+	if err != nil {
+		return err
+	}
+	if c.IRuleInclude == "" {
+		c.IRuleInclude = ".*"
+	}
+	r, err := irule.NewIRule(viper.GetString("index"), c.IRuleInclude, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := r.Gather(data)
+	if err != nil {
+		return nil
+	}
+	r.Check(result, thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run a profile check, see the profile subcommand
+func runBundleProfile(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	if c.ProfileName == "" {
+		return errors.New("Profile not specified")
+	}
+	if c.ProfileType == "" {
+		c.ProfileType = "http"
+	}
+	thresholds, err := profile.MergeThresholds(c.ProfileType, c.Warning, c.Critical, c.Thresholds, c.Threshold)
+	if err != nil {
+		return err
+	}
+	p, err := profile.NewProfile(viper.GetString("index"), c.ProfileType, c.ProfileName, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := p.Gather(data)
+	if err != nil {
+		return nil
+	}
+	p.Check(result, thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run a GTM check, see the gtm subcommand
+func runBundleGtm(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	if c.GtmName == "" {
+		return errors.New("GTM object name not specified")
+	}
+	if c.GtmType == "" {
+		c.GtmType = "wideip"
+	}
+	if c.RecordType == "" {
+		c.RecordType = "a"
+	}
+	g, err := gtm.NewGtm(viper.GetString("index"), c.GtmType, c.RecordType, c.GtmName, c.IgnoreDisabled, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := g.Gather(data)
+	if err != nil {
+		return nil
+	}
+	g.Check(result, c.Warning, c.Critical, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Run an ASM check, see the asm subcommand
+func runBundleAsm(c bundleCheck, data *elasticsearch.ElasticsearchResult, schedule *maintenance.Schedule, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) error {
+	var signatureWarn, signatureCrit time.Duration
+	var err error
+	if c.SignatureAgeWarning != "" {
+		if signatureWarn, err = units.ParseDuration(c.SignatureAgeWarning); err != nil {
+			return err
+		}
+	}
+	if c.SignatureAgeCritical != "" {
+		if signatureCrit, err = units.ParseDuration(c.SignatureAgeCritical); err != nil {
+			return err
+		}
+	}
+	thresholds, err := threshold.Merge("", "", nil, c.Thresholds, c.Threshold, func(Name string) (string, bool) { return Name, true })
+	if err != nil {
+		return err
+	}
+	counters := make([]string, 0, len(thresholds))
+	for counter := range thresholds {
+		counters = append(counters, counter)
+	}
+	sort.Strings(counters)
+	a, err := asm.NewAsm(viper.GetString("index"), counters, connection, nagios)
+	if err != nil {
+		return err
+	}
+	result, err := a.Gather(data)
+	if err != nil {
+		return nil
+	}
+	a.Check(result, signatureWarn, signatureCrit, thresholds, viper.GetString("age-warning"), viper.GetString("age-critical"))
+	return nil
+}
+
+// Matches the labels of the performance data rendered by nagiosplugin
+var perfLabel = regexp.MustCompile(`'([^']*)'=`)
+
+// Split the output of a check into the text and the performance data
+func splitOutput(output string) (string, string) {
+	lines := strings.SplitN(output, "\n", 2)
+	first := strings.SplitN(lines[0], " | ", 2)
+	text := first[0]
+	if len(lines) > 1 {
+		text += "\n" + lines[1]
+	}
+	if len(first) < 2 {
+		return text, ""
+	}
+	return text, first[1]
+}
+
+// Combine the results into a single check result. The status is the worst
+// status of all checks, every check gets its own section and the performance
+// data labels are prefixed with the name of the check.
+func aggregateResults(results []bundleResult) (nagiosplugin.Status, string) {
+	var status nagiosplugin.Status
+	var sections, perfdata []string
+
+	count := make(map[nagiosplugin.Status]int)
+	for _, r := range results {
+		if r.status > status {
+			status = r.status
+		}
+		count[r.status]++
+		text, perf := splitOutput(r.output)
+		sections = append(sections, "["+r.check.Name+"] "+strings.TrimRight(text, "\n"))
+		if perf != "" {
+			perfdata = append(perfdata, perfLabel.ReplaceAllString(perf, "'"+r.check.Name+"::$1'="))
+		}
+	}
+	summary := make([]string, 0, 4)
+	for _, s := range []nagiosplugin.Status{nagiosplugin.OK, nagiosplugin.WARNING, nagiosplugin.CRITICAL, nagiosplugin.UNKNOWN} {
+		if count[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%v %v", count[s], s))
+		}
+	}
+	output := fmt.Sprintf("%v: %v checks (%v)", status, len(results), strings.Join(summary, ", "))
+	if len(perfdata) > 0 {
+		output += " | " + strings.Join(perfdata, " ")
+	}
+	return status, output + "\n" + strings.Join(sections, "\n")
+}
+
+// Append the results as PROCESS_SERVICE_CHECK_RESULT external commands to the
+// file, which may be the command pipe of Nagios/Icinga2. The service name
+// defaults to the name of the check.
+func writePassiveResults(File string, Host string, Results []bundleResult) error {
+	f, err := os.OpenFile(File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := time.Now().Unix()
+	for _, r := range Results {
+		service := r.check.Service
+		if service == "" {
+			service = r.check.Name
+		}
+		output := strings.ReplaceAll(strings.TrimRight(r.output, "\n"), "\n", "\\n")
+		if _, err = fmt.Fprintf(f, "[%v] PROCESS_SERVICE_CHECK_RESULT;%v;%v;%d;%v\n", now, Host, service, r.status, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,32 +2,65 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/forecast"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// Load the configuration file if the parameter is set.
+// Load the configuration file if the parameter is set and apply the profile
+// selected with --profile.
 func HandleConfigFile() error {
 	logger := log.With().Str("func", "rootCmd.HandleConfigFile").Str("package", "cmd").Logger()
 	if ConfigFile != "" {
 		logger.Debug().Str("file", ConfigFile).Msg("Read config from " + ConfigFile)
 		viper.SetConfigFile(ConfigFile)
 
-		if err := viper.ReadInConfig(); err != nil {
+		if err := readConfigFile(ConfigFile); err != nil {
 			logger.Error().Err(err).Msg("Could not read config file")
 			return err
 		}
 	}
+	if ConfigProfile != "" {
+		if err := applyProfile(ConfigProfile); err != nil {
+			logger.Error().Str("profile", ConfigProfile).Err(err).Msg("Could not apply profile")
+			return err
+		}
+	}
 	return nil
 }
 
+// The keys renamed to the hyphenated spelling of the flags
+var legacyKeys = map[string]string{
+	"age_warning":     "age-warning",
+	"age_critical":    "age-critical",
+	"ignore_disabled": "ignore-disabled",
+}
+
+// Copy the values of the old keys in the configuration file to the new keys,
+// unless the new key is set as well.
+func renameLegacyKeys() error {
+	settings := map[string]interface{}{}
+	for old, key := range legacyKeys {
+		if viper.InConfig(old) && !viper.InConfig(key) {
+			settings[key] = viper.Get(old)
+		}
+	}
+	return viper.MergeConfigMap(settings)
+}
+
 // Configure the logging.
 func setupLogging() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -45,27 +78,34 @@ func setupLogging() {
 	}
 	log.Logger = zerolog.New(output).With().Timestamp().Logger()
 
-	switch strings.ToUpper(viper.GetString("loglevel")) {
+	level, err := parseLogLevel(viper.GetString("loglevel"))
+	if err != nil {
+		log.Error().Str("id", "ERR00001").Err(err).Msg("")
+		os.Exit(3)
+	}
+	zerolog.SetGlobalLevel(level)
+	log.Debug().Str("id", "DBG00001").Str("func", "setupLogging").Str("logfile", LogFile).Msg("Logging to " + LogFile)
+}
+
+// Parse one of the zerolog log levels, ignoring the case
+func parseLogLevel(Level string) (zerolog.Level, error) {
+	switch strings.ToUpper(Level) {
 	case "TRACE":
-		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+		return zerolog.TraceLevel, nil
 	case "DEBUG":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		return zerolog.DebugLevel, nil
 	case "INFO":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		return zerolog.InfoLevel, nil
 	case "WARN":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+		return zerolog.WarnLevel, nil
 	case "ERROR":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+		return zerolog.ErrorLevel, nil
 	case "FATAL":
-		zerolog.SetGlobalLevel(zerolog.FatalLevel)
+		return zerolog.FatalLevel, nil
 	case "PANIC":
-		zerolog.SetGlobalLevel(zerolog.PanicLevel)
-	default:
-		err := errors.New("Illegal log level " + LogLevel)
-		log.Error().Str("id", "ERR00001").Err(err).Msg("")
-		os.Exit(3)
+		return zerolog.PanicLevel, nil
 	}
-	log.Debug().Str("id", "DBG00001").Str("func", "setupLogging").Str("logfile", LogFile).Msg("Logging to " + LogFile)
+	return zerolog.NoLevel, errors.New("Illegal log level " + Level)
 }
 
 // Parse the timeout string into a go duration
@@ -75,4 +115,138 @@ func parseTimeout(timeout string) (time.Duration, error) {
 		return t, err
 	}
 	return t, nil
-}
\ No newline at end of file
+}
+
+// Parse the window and validate the aggregation for trend checks. A zero
+// duration means the check uses the latest document.
+func parseTrend(window string, aggregation string) (time.Duration, error) {
+	w, err := parseWindow(window)
+	if err != nil || w == 0 {
+		return w, err
+	}
+	return w, trend.ValidateAggregation(aggregation)
+}
+
+// Parse the window of the checks which don't aggregate metrics. An empty
+// window results in 0.
+func parseWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return 0, nil
+	}
+	w, err := units.ParseDuration(window)
+	if err != nil {
+		return w, err
+	}
+	if w <= 0 {
+		return w, errors.New("The window must be positive")
+	}
+	return w, nil
+}
+
+// Parameters of a forecast, see parseForecast
+type forecastOptions struct {
+	window time.Duration
+	warn   time.Duration
+	crit   time.Duration
+	limit  float64
+}
+
+// Parse the forecast parameters. A nil result means no forecast was
+// requested. defaultLimit is used if no limit is given, an empty
+// defaultLimit makes the limit mandatory.
+func parseForecast(defaultLimit string) (*forecastOptions, error) {
+	var err error
+	if viper.GetString("forecast-window") == "" {
+		return nil, nil
+	}
+	o := new(forecastOptions)
+	if o.window, err = units.ParseDuration(viper.GetString("forecast-window")); err != nil {
+		return nil, err
+	}
+	if viper.GetString("forecast-warning") != "" {
+		if o.warn, err = units.ParseDuration(viper.GetString("forecast-warning")); err != nil {
+			return nil, err
+		}
+	}
+	if viper.GetString("forecast-critical") != "" {
+		if o.crit, err = units.ParseDuration(viper.GetString("forecast-critical")); err != nil {
+			return nil, err
+		}
+	}
+	limit := viper.GetString("forecast-limit")
+	if limit == "" {
+		limit = defaultLimit
+	}
+	if limit == "" {
+		return nil, errors.New("A forecast needs a limit")
+	}
+	if o.limit, err = units.ParseNumber(limit); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Run the forecast for the metrics and add the results to the check
+func runForecast(options *forecastOptions, metrics map[string]forecast.Metric, connection *elasticsearch.Elasticsearch, nagios *nagiosplugin.Check) {
+	logger := log.With().Str("func", "runForecast").Str("package", "cmd").Logger()
+	f, err := forecast.NewForecast(viper.GetString("index"), options.window, options.warn, options.crit, connection, nagios)
+	if err != nil {
+		nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create forecast: "+err.Error())
+		logger.Error().Str("id", "00010037").Err(err).Msg("Could not create forecast")
+		return
+	}
+	points, err := f.Execute(metrics)
+	if err != nil {
+		return
+	}
+	f.Check(metrics, points, options.limit)
+}
+
+// Load the maintenance windows from the configuration file
+func loadMaintenance() (*maintenance.Schedule, error) {
+	var windows []maintenance.Window
+	if err := viper.UnmarshalKey("maintenance", &windows); err != nil {
+		return nil, err
+	}
+	return maintenance.NewSchedule(windows)
+}
+
+// Print the result and exit. If the scope is in a maintenance window of the
+// schedule, a warning or critical result is reported as OK with an annotation
+// like "in maintenance until 02:00", the original status and the performance
+// data are kept in the output.
+func finish(nagios *nagiosplugin.Check, schedule *maintenance.Schedule, scope maintenance.Scope) {
+	if status, output, downgraded := checkOutput(nagios, schedule, scope); downgraded {
+		fmt.Println(output)
+		os.Exit(int(status))
+	}
+	nagios.Finish()
+}
+
+// Render the check and apply the maintenance windows, see finish. The last
+// return value is true if the result was downgraded.
+func checkOutput(nagios *nagiosplugin.Check, schedule *maintenance.Schedule, scope maintenance.Scope) (nagiosplugin.Status, string, bool) {
+	logger := log.With().Str("func", "checkOutput").Str("package", "cmd").Logger()
+	output := nagios.String()
+	status := outputStatus(output)
+	if status != nagiosplugin.WARNING && status != nagiosplugin.CRITICAL {
+		return status, output, false
+	}
+	annotation := schedule.Annotation(scope, time.Now())
+	if annotation == "" {
+		return status, output, false
+	}
+	logger.Info().Str("id", "INF00010001").Str("status", status.String()).Str("annotation", annotation).Msg("Problem downgraded to OK")
+	output = fmt.Sprintf("%v: %v (%v): %v", nagiosplugin.OK, annotation, status, strings.TrimPrefix(output, status.String()+": "))
+	return nagiosplugin.OK, output, true
+}
+
+// The status of a rendered check, taken from the prefix of the output
+func outputStatus(output string) nagiosplugin.Status {
+	for _, status := range []nagiosplugin.Status{nagiosplugin.OK, nagiosplugin.WARNING, nagiosplugin.CRITICAL, nagiosplugin.UNKNOWN} {
+		if strings.HasPrefix(output, status.String()+":") {
+			return status
+		}
+	}
+	return nagiosplugin.UNKNOWN
+}
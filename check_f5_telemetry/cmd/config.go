@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/connections"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/gtm"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/interfaces"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/irule"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/pool"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/profile"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/system"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/telemetry"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/throughput"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "config" groups the commands handling the configuration
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Handle the configuration",
+	Long:  `Commands for handling the configuration file of check_f5_telemetry`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		return
+	},
+}
+
+// The subcommand "config validate" checks the configuration file and the
+// flags without running a check.
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration",
+	Long:  `Load the configuration file, parse every duration, range and pattern, test the connection to elasticsearch and the index and report all problems found`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Problems with the logging or the configuration file are reported by
+		// the validation instead of aborting the command.
+		if _, err := parseLogLevel(viper.GetString("loglevel")); err == nil {
+			setupLogging()
+		} else {
+			zerolog.SetGlobalLevel(zerolog.Disabled)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.With().Str("func", "configValidate.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		problems := validateConfig(viper.GetBool("offline"))
+		if len(problems) == 0 {
+			fmt.Println("Configuration is valid")
+			return
+		}
+		fmt.Printf("Found %v problems in the configuration:\n", len(problems))
+		for _, p := range problems {
+			fmt.Println("  - " + p)
+		}
+		logger.Error().Str("id", "00010050").Int("problems", len(problems)).Msg("Invalid configuration")
+		os.Exit(1)
+	},
+}
+
+// Collects the problems found by validateConfig
+type configProblems []string
+
+// Add a problem if err is not nil
+func (p *configProblems) check(What string, err error) {
+	if err != nil {
+		*p = append(*p, What+": "+err.Error())
+	}
+}
+
+// Validate the configuration file and the flags. Unless offline is set, the
+// connection to elasticsearch and the existence of the index are tested.
+func validateConfig(offline bool) []string {
+	var p configProblems
+	var err error
+
+	if ConfigFile != "" {
+		viper.SetConfigFile(ConfigFile)
+		p.check("config file "+ConfigFile, readConfigFile(ConfigFile))
+	}
+	if ConfigProfile != "" {
+		p.check("profile "+ConfigProfile, applyProfile(ConfigProfile))
+	}
+	_, err = parseLogLevel(viper.GetString("loglevel"))
+	p.check("loglevel", err)
+
+	parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+	p.check("timeout", err)
+	for _, key := range []string{"age-warning", "age-critical", "baseline-slot", "poll-interval", "lookback", "signature-age-warning", "signature-age-critical", "membership-age"} {
+		if viper.GetString(key) != "" {
+			_, err = units.ParseDuration(viper.GetString(key))
+			p.check(key, err)
+		}
+	}
+	_, err = parseTrend(viper.GetString("window"), viper.GetString("aggregation"))
+	p.check("window", err)
+	_, err = parseForecast(system.DefaultForecastLimit)
+	p.check("forecast", err)
+	if err == nil {
+		// Only the system check has a default limit
+		_, err = parseForecast("")
+		p.check("throughput forecast", err)
+	}
+
+	for _, pair := range [][2]string{
+		{"warning", "critical"},
+		{"imbalance-warning", "imbalance-critical"},
+		{"utilization-warning", "utilization-critical"},
+		{"unavailable-warning", "unavailable-critical"},
+	} {
+		_, err = threshold.Threshold{Warning: viper.GetString(pair[0]), Critical: viper.GetString(pair[1])}.Expand()
+		p.check(pair[0]+"/"+pair[1], err)
+	}
+	for _, d := range viper.GetStringSlice("threshold") {
+		_, _, err = threshold.Parse(d)
+		p.check("threshold", err)
+	}
+	for _, key := range []string{"deviation-warning", "deviation-critical"} {
+		_, err = throughput.ParseDeviation(viper.GetString(key))
+		p.check(key, err)
+	}
+	for _, m := range viper.GetStringSlice("baseline-metrics") {
+		if _, ok := throughput.MetricName(m); !ok {
+			p = append(p, "baseline-metrics: unknown metric "+m)
+		}
+	}
+	p = append(p, validateThresholdConfig()...)
+
+	severity, err := pool.ParseSeverity(viper.GetString("member-severity"))
+	p.check("member-severity", err)
+	if viper.GetString("imbalance-metric") != "" {
+		p.check("imbalance-metric", pool.ValidateImbalanceMetric(viper.GetString("imbalance-metric")))
+	}
+	_, err = pool.NewPool(viper.GetString("index"), viper.GetString("pool"), false, pool.MemberOptions{
+		Include:  viper.GetString("member-include"),
+		Exclude:  viper.GetString("member-exclude"),
+		Severity: severity,
+	}, nil, nil)
+	p.check("member patterns", err)
+	_, err = interfaces.NewInterfaces(viper.GetString("index"), viper.GetString("interface-include"), viper.GetString("interface-exclude"), nil, nil)
+	p.check("interface patterns", err)
+	_, err = irule.NewIRule(viper.GetString("index"), viper.GetString("irule-include"), nil, nil)
+	p.check("irule-include", err)
+	_, err = gtm.NewGtm(viper.GetString("index"), viper.GetString("gtm-type"), viper.GetString("record-type"), viper.GetString("gtm-name"), false, nil, nil)
+	p.check("gtm", err)
+	_, err = profile.NewProfile(viper.GetString("index"), viper.GetString("profile-type"), viper.GetString("profile-name"), nil, nil)
+	p.check("profile-type", err)
+
+	_, err = loadMaintenance()
+	p.check("maintenance", err)
+	if viper.IsSet("bundle") {
+		p = append(p, validateBundle()...)
+	}
+
+	if !offline {
+		if parsedTimeout <= 0 {
+			parsedTimeout = 2 * time.Minute
+		}
+		p.check("elasticsearch", testConnection(parsedTimeout))
+	}
+	return p
+}
+
+// Validate the threshold maps of the subcommands in the configuration file.
+// Every section is checked and the problems are returned in the order of the
+// section names.
+func validateThresholdConfig() []string {
+	var p configProblems
+	interval, err := units.ParseDuration(viper.GetString("poll-interval"))
+	if err != nil {
+		interval = time.Minute
+	}
+	merge := map[string]func(map[string]threshold.Threshold) (threshold.Thresholds, error){
+		"throughput": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return throughput.MergeThresholds("", "", Config, nil)
+		},
+		"connections": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return connections.MergeThresholds("", "", Config, nil)
+		},
+		"interfaces": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return interfaces.MergeThresholds("", "", Config, nil)
+		},
+		"irule": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return irule.MergeThresholds("", "", Config, nil)
+		},
+		"telemetry": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return telemetry.MergeThresholds("", "", Config, nil, interval)
+		},
+		"profile": func(Config map[string]threshold.Threshold) (threshold.Thresholds, error) {
+			return profile.MergeThresholds(viper.GetString("profile-type"), "", "", Config, nil)
+		},
+	}
+	sections := make([]string, 0, len(merge))
+	for section := range merge {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		key := section + ".thresholds"
+		if section == "throughput" {
+			key = throughputThresholdsKey()
+		}
+		var config map[string]threshold.Threshold
+		if err := viper.UnmarshalKey(key, &config); err != nil {
+			p.check("thresholds "+key, err)
+			continue
+		}
+		_, err := merge[section](config)
+		p.check("thresholds "+key, err)
+	}
+	return p
+}
+
+// Validate the checks of the bundle by running them against an empty search
+// result. Only problems with the definition of a check are reported, the
+// missing data is ignored.
+func validateBundle() []string {
+	var p configProblems
+	checks, err := loadBundle()
+	if err != nil {
+		p.check("bundle", err)
+		return p
+	}
+	for _, c := range checks {
+		err = bundleTypes[c.Type](c, new(elasticsearch.ElasticsearchResult), nil, nil, nagiosplugin.NewCheck())
+		p.check("bundle check "+c.Name, err)
+	}
+	return p
+}
+
+// Connect to elasticsearch and check, if the index exists
+func testConnection(Timeout time.Duration) error {
+	connection, err := elasticsearch.NewElasticsearch(
+		viper.GetBool("ssl"),
+		viper.GetString("host"),
+		viper.GetInt("port"),
+		viper.GetString("user"),
+		viper.GetString("password"),
+		viper.GetBool("validatessl"),
+		viper.GetString("proxy"),
+		viper.GetBool("socks"),
+		Timeout,
+	)
+	if err != nil {
+		return err
+	}
+	data, err := connection.Search(viper.GetString("index"), "{\"size\":0,\"query\":{\"match_all\":{}}}")
+	if err != nil {
+		if data != nil && data.Error.Reason != "" {
+			return fmt.Errorf("index %v: %v (%v)", viper.GetString("index"), data.Error.Reason, err)
+		}
+		return fmt.Errorf("index %v: %v", viper.GetString("index"), err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestValidateThresholdConfig(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("throughput.thresholds", map[string]interface{}{"noSuchMetric": map[string]interface{}{"warning": "1"}})
+	viper.Set("irule.thresholds", map[string]interface{}{"noSuchMetric": map[string]interface{}{"warning": "1"}})
+	viper.Set("connections.thresholds", map[string]interface{}{"clientConnections": map[string]interface{}{"warning": "5x"}})
+	viper.Set("interfaces.thresholds", map[string]interface{}{})
+
+	for i := 0; i < 5; i++ {
+		problems := validateThresholdConfig()
+		want := []string{"thresholds connections.thresholds", "thresholds irule.thresholds", "thresholds throughput.thresholds"}
+		if len(problems) != len(want) {
+			t.Fatalf("validateThresholdConfig() = %q, want %v problems", problems, len(want))
+		}
+		for n, p := range problems {
+			if !strings.HasPrefix(p, want[n]+": ") {
+				t.Errorf("validateThresholdConfig()[%v] = %q, want prefix %q", n, p, want[n])
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	// "os"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/connections"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "check" to execute a check. This is called by Nagios/Icinga2
+var connectionsCmd = &cobra.Command{
+	Use:   "connections",
+	Short: "Check connections",
+	Long:  `Check F5 connections performance based on telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var c *connections.Connections
+		logger := log.With().Str("func", "connections.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020002").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		var config map[string]threshold.Threshold
+		if err = viper.UnmarshalKey("connections.thresholds", &config); err != nil {
+			logger.Error().Str("id", "00010006").Err(err).Msg("Could not read thresholds from config")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read thresholds from config")
+			return
+		}
+		thresholds, err := connections.MergeThresholds(viper.GetString("warning"),
+			viper.GetString("critical"),
+			config,
+			viper.GetStringSlice("threshold"))
+		if err != nil {
+			logger.Error().Str("id", "00010007").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		window, err := parseTrend(viper.GetString("window"), viper.GetString("aggregation"))
+		if err != nil {
+			logger.Error().Str("id", "00010031").Err(err).Msg("Invalid window or aggregation")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010064").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		c, err = connections.NewConnections(viper.GetString("index"), elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connections check: "+err.Error())
+			logger.Error().Str("id", "00010060").Err(err).Msg("Could not create connections check")
+			return
+		}
+		if window > 0 {
+			err = c.ExecuteTrend(window, viper.GetString("aggregation"))
+		} else {
+			err = c.Execute()
+		}
+		if err != nil {
+			return
+		}
+		c.Check(thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
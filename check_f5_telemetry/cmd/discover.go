@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/discovery"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "discover" lists the objects found in the telemetry data
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "List pools, virtual servers, devices and interfaces",
+	Long:  `Read the newest telemetry document and list the pools, virtual servers, devices and interfaces found as table, JSON or Icinga2 configuration`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.With().Str("func", "discover.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020012").Err(err).Msg("Could not parse timeout")
+			fmt.Fprintln(os.Stderr, "Could not parse timeout: "+err.Error())
+			os.Exit(1)
+		}
+		if !validFormat(viper.GetString("format"), discovery.Formats) {
+			logger.Error().Str("id", "00010051").Str("format", viper.GetString("format")).Msg("Unknown format")
+			fmt.Fprintf(os.Stderr, "Unknown format %v, use one of %v\n", viper.GetString("format"), strings.Join(discovery.Formats, ", "))
+			os.Exit(1)
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			return
+		}
+
+		inventory, err := discover(elasticsearch)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		if err = inventory.Write(os.Stdout, viper.GetString("format")); err != nil {
+			logger.Error().Str("id", "00010053").Err(err).Msg("Could not write inventory")
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// Read the inventory of the device given by --device or the newest document
+func discover(connection *elasticsearch.Elasticsearch) (*discovery.Inventory, error) {
+	logger := log.With().Str("func", "discover").Str("package", "cmd").Logger()
+	d, err := discovery.NewDiscovery(viper.GetString("index"), viper.GetString("device-field"), viper.GetString("device"), connection)
+	if err != nil {
+		logger.Error().Str("id", "00010052").Err(err).Msg("Could not create discovery")
+		return nil, err
+	}
+	return d.Execute()
+}
+
+// Check, if the format is one of the supported formats
+func validFormat(Format string, Formats []string) bool {
+	for _, f := range Formats {
+		if f == Format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/discovery"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// The subcommands running a check, for which CheckCommands are generated
+func checkCommands() []*cobra.Command {
+	return []*cobra.Command{poolCmd, throughputCmd, connectionsCmd, asmCmd, interfacesCmd, gtmCmd, profileCmd, iruleCmd, systemCmd, telemetryCmd, bundleCmd}
+}
+
+// The default template for the services, see generate services
+const defaultServiceTemplate = `{{range .Pools}}object Service {{quote (print "f5_pool_" (objectname .Name))}} {
+  host_name = {{quote $.Host}}
+  check_command = "check_f5_telemetry_pool"
+  display_name = {{quote (print "Pool " .Name)}}
+  vars.cf5_pool = {{quote .Name}}
+  vars.cf5_device = {{quote $.Device}}
+}
+
+{{end}}{{range .VirtualServers}}{{if .Pool}}object Service {{quote (print "f5_vs_" (objectname .Name))}} {
+  host_name = {{quote $.Host}}
+  check_command = "check_f5_telemetry_pool"
+  display_name = {{quote (print "Virtual server " .Name " (" .Destination ")")}}
+  vars.cf5_pool = {{quote .Pool}}
+  vars.cf5_device = {{quote $.Device}}
+}
+
+{{end}}{{end}}`
+
+// The data passed to the service template
+type serviceTemplateData struct {
+	*discovery.Inventory
+	Host string
+}
+
+// The subcommand "generate" groups the generators for the Icinga2
+// configuration
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate Icinga2 configuration",
+	Long:  `Generate the Icinga2 CheckCommands from the flags of the subcommands or Service objects for the objects found in the telemetry data`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+		return
+	},
+}
+
+// The subcommand "generate commands" renders the CheckCommand definitions
+var generateCommandsCmd = &cobra.Command{
+	Use:   "commands [subcommand...]",
+	Short: "Generate Icinga2 CheckCommands",
+	Long:  `Generate an Icinga2 CheckCommand for every subcommand (or the given ones) with an argument for every flag`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.With().Str("func", "generateCommands.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		commands, err := selectCommands(args)
+		if err != nil {
+			logger.Error().Str("id", "00010054").Err(err).Msg("Unknown subcommand")
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		for _, c := range commands {
+			writeCheckCommand(os.Stdout, c)
+		}
+	},
+}
+
+// The subcommand "generate services" renders Service objects for the
+// discovered pools and virtual servers
+var generateServicesCmd = &cobra.Command{
+	Use:   "services",
+	Short: "Generate Icinga2 services for the discovered objects",
+	Long:  `Read the newest telemetry document like discover and render a Service object for every pool and virtual server using a template`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := log.With().Str("func", "generateServices.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		tmpl, err := serviceTemplate(viper.GetString("template"))
+		if err != nil {
+			logger.Error().Str("id", "00010055").Str("template", viper.GetString("template")).Err(err).Msg("Could not load template")
+			fmt.Fprintln(os.Stderr, "Could not load template: "+err.Error())
+			os.Exit(1)
+		}
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020013").Err(err).Msg("Could not parse timeout")
+			fmt.Fprintln(os.Stderr, "Could not parse timeout: "+err.Error())
+			os.Exit(1)
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			return
+		}
+
+		inventory, err := discover(elasticsearch)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		data := serviceTemplateData{Inventory: inventory, Host: viper.GetString("icinga-host")}
+		if data.Host == "" {
+			data.Host = inventory.Device
+		}
+		if err = tmpl.Execute(os.Stdout, data); err != nil {
+			logger.Error().Str("id", "00010056").Err(err).Msg("Could not render template")
+			fmt.Fprintln(os.Stderr, "Could not render template: "+err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+// Select the subcommands by their names, no names select all
+func selectCommands(Names []string) ([]*cobra.Command, error) {
+	if len(Names) == 0 {
+		return checkCommands(), nil
+	}
+	commands := make([]*cobra.Command, 0, len(Names))
+	for _, name := range Names {
+		found := false
+		for _, c := range checkCommands() {
+			if c.Name() == name {
+				commands = append(commands, c)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown subcommand %v", name)
+		}
+	}
+	return commands, nil
+}
+
+// Load the service template from the file or use the default template
+func serviceTemplate(File string) (*template.Template, error) {
+	text := defaultServiceTemplate
+	if File != "" {
+		content, err := os.ReadFile(File)
+		if err != nil {
+			return nil, err
+		}
+		text = string(content)
+	}
+	return template.New("services").Funcs(template.FuncMap{
+		"quote":      discovery.IcingaString,
+		"objectname": discovery.ObjectName,
+		"regex":      regexp.QuoteMeta,
+	}).Parse(text)
+}
+
+// The flags of a subcommand including the inherited ones, sorted by name.
+// The help flag and the password, which is passed in the environment, are
+// left out.
+func commandFlags(Command *cobra.Command) []*pflag.Flag {
+	flags := make([]*pflag.Flag, 0)
+	add := func(f *pflag.Flag) {
+		if f.Name != "help" && f.Name != "password" {
+			flags = append(flags, f)
+		}
+	}
+	Command.LocalFlags().VisitAll(add)
+	Command.InheritedFlags().VisitAll(add)
+	sort.Slice(flags, func(a, b int) bool { return flags[a].Name < flags[b].Name })
+	return flags
+}
+
+// The name of the custom variable for a flag
+func flagVariable(Name string) string {
+	return "cf5_" + strings.ReplaceAll(Name, "-", "_")
+}
+
+// Write the CheckCommand for a subcommand. Every flag is passed as argument
+// using the custom variable cf5_<flag>, boolean flags defaulting to true are
+// disabled with cf5_no_<flag>. List flags are repeated for every element of
+// an array.
+func writeCheckCommand(w io.Writer, Command *cobra.Command) {
+	fmt.Fprintf(w, "object CheckCommand %v {\n", discovery.IcingaString("check_f5_telemetry_"+strings.ReplaceAll(Command.Name(), "-", "_")))
+	fmt.Fprintf(w, "  import \"plugin-check-command\"\n\n")
+	fmt.Fprintf(w, "  command = [ PluginDir + \"/check_f5_telemetry\", %v, ]\n", discovery.IcingaString(Command.Name()))
+	fmt.Fprintf(w, "  env = {\n    CF5_PASSWORD = \"$cf5_password$\"\n  }\n")
+	fmt.Fprintf(w, "  arguments = {\n")
+	for _, f := range commandFlags(Command) {
+		key := "--" + f.Name
+		condition := "value"
+		variable := flagVariable(f.Name)
+		description := f.Usage
+		if f.Value.Type() == "bool" {
+			condition = "set_if"
+			if f.DefValue == "true" {
+				key += "=false"
+				variable = flagVariable("no_" + f.Name)
+				description = "Disable: " + f.Usage
+			}
+		}
+		fmt.Fprintf(w, "    %v = {\n", discovery.IcingaString(key))
+		fmt.Fprintf(w, "      required = false\n")
+		fmt.Fprintf(w, "      %v = \"$%v$\"\n", condition, variable)
+		if strings.HasSuffix(f.Value.Type(), "Slice") || strings.HasSuffix(f.Value.Type(), "Array") {
+			fmt.Fprintf(w, "      repeat_key = true\n")
+		}
+		fmt.Fprintf(w, "      description = %v\n", discovery.IcingaString(description))
+		fmt.Fprintf(w, "    }\n")
+	}
+	fmt.Fprintf(w, "  }\n}\n\n")
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/gtm"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "gtm" checks wide IPs and GSLB pools on BIG-IP DNS devices
+var gtmCmd = &cobra.Command{
+	Use:   "gtm",
+	Short: "Check GTM wide IPs and pools",
+	Long:  `Check F5 BIG-IP DNS wide IP and GSLB pool availability in telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var g *gtm.Gtm
+
+		logger := log.With().Str("func", "gtm.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020006").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+		if viper.GetString("gtm-name") == "" {
+			logger.Error().Str("id", "00010013").Msg("GTM object name not specified")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "GTM object name not specified")
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010065").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		g, err = gtm.NewGtm(viper.GetString("index"),
+			viper.GetString("gtm-type"),
+			viper.GetString("record-type"),
+			viper.GetString("gtm-name"),
+			viper.GetBool("ignore-disabled"),
+			elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create gtm check: "+err.Error())
+			logger.Error().Str("id", "00010014").Err(err).Msg("Could not create gtm check")
+			return
+		}
+		result, err := g.Execute()
+		if err != nil {
+			return
+		}
+		g.Check(result, viper.GetString("warning"),
+			viper.GetString("critical"),
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
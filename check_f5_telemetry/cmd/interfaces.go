@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	// "os"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/interfaces"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "check" to execute a check. This is called by Nagios/Icinga2
+var interfacesCmd = &cobra.Command{
+	Use:   "interfaces",
+	Short: "Check network interfaces",
+	Long:  `Check F5 network interface status and error rates based on telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var i *interfaces.Interfaces
+		logger := log.With().Str("func", "interfaces.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020005").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		var config map[string]threshold.Threshold
+		if err = viper.UnmarshalKey("interfaces.thresholds", &config); err != nil {
+			logger.Error().Str("id", "00010011").Err(err).Msg("Could not read thresholds from config")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read thresholds from config")
+			return
+		}
+		thresholds, err := interfaces.MergeThresholds(viper.GetString("warning"),
+			viper.GetString("critical"),
+			config,
+			viper.GetStringSlice("threshold"))
+		if err != nil {
+			logger.Error().Str("id", "00010012").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010066").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		i, err = interfaces.NewInterfaces(viper.GetString("index"),
+			viper.GetString("interface-include"),
+			viper.GetString("interface-exclude"),
+			elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create interfaces check: "+err.Error())
+			logger.Error().Str("id", "00010016").Err(err).Msg("Could not create interfaces check")
+			return
+		}
+		result, err := i.Execute()
+		if err != nil {
+			return
+		}
+		i.Check(result, thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
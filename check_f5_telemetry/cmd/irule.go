@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	// "os"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/irule"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "check" to execute a check. This is called by Nagios/Icinga2
+var iruleCmd = &cobra.Command{
+	Use:   "irule",
+	Short: "Check iRule statistics",
+	Long:  `Check F5 iRule executions, failures, aborts and CPU cycles based on telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var r *irule.IRule
+		logger := log.With().Str("func", "irule.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020008").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		var config map[string]threshold.Threshold
+		if err = viper.UnmarshalKey("irule.thresholds", &config); err != nil {
+			logger.Error().Str("id", "00010021").Err(err).Msg("Could not read thresholds from config")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read thresholds from config")
+			return
+		}
+		thresholds, err := irule.MergeThresholds(viper.GetString("warning"),
+			viper.GetString("critical"),
+			config,
+			viper.GetStringSlice("threshold"))
+		if err != nil {
+			logger.Error().Str("id", "00010022").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010067").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		r, err = irule.NewIRule(viper.GetString("index"),
+			viper.GetString("irule-include"),
+			elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create iRule check: "+err.Error())
+			logger.Error().Str("id", "00010023").Err(err).Msg("Could not create iRule check")
+			return
+		}
+		result, err := r.Execute()
+		if err != nil {
+			return
+		}
+		r.Check(result, thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
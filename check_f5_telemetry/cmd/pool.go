@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 	// "os"
 
 	"github.com/joernott/nagiosplugin/v2"
 
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/pool"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -47,6 +50,51 @@ var poolCmd = &cobra.Command{
 			return
 		}
 
+		severity, err := pool.ParseSeverity(viper.GetString("member-severity"))
+		if err != nil {
+			logger.Error().Str("id","00010002").Err(err).Msg("Could not parse member severity")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse member severity")
+			return
+		}
+
+		imbalance := viper.GetString("imbalance-metric")
+		if imbalance != "" {
+			if err = pool.ValidateImbalanceMetric(imbalance); err != nil {
+				logger.Error().Str("id","00010003").Err(err).Msg("Illegal imbalance metric")
+				nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+				return
+			}
+		}
+
+		window, err := parseWindow(viper.GetString("window"))
+		if err != nil {
+			logger.Error().Str("id", "00010032").Err(err).Msg("Invalid window")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		var membershipAge time.Duration
+		if viper.GetString("membership-age") != "" {
+			membershipAge, err = units.ParseDuration(viper.GetString("membership-age"))
+			if err != nil || membershipAge <= 0 {
+				logger.Error().Str("id", "00010057").Err(err).Str("membership-age", viper.GetString("membership-age")).Msg("Invalid membership age")
+				nagios.AddResult(nagiosplugin.UNKNOWN, "Invalid membership age "+viper.GetString("membership-age"))
+				return
+			}
+		}
+		if viper.GetBool("membership-accept") && viper.GetString("membership-state") == "" {
+			logger.Error().Str("id", "00010058").Msg("Membership accept without state file")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "--membership-accept requires --membership-state")
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010043").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
 		elasticsearch, err := elasticsearch.NewElasticsearch(
 			viper.GetBool("ssl"),
 			viper.GetString("host"),
@@ -64,12 +112,26 @@ var poolCmd = &cobra.Command{
 			nagios.Finish()
 			return
 		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
 
-		p, err = pool.NewPool(viper.GetString("index"), viper.GetString("pool"), viper.GetBool("ignore_disabled"), elasticsearch, nagios)
+		members := pool.MemberOptions{
+			Include:      viper.GetString("member-include"),
+			Exclude:      viper.GetString("member-exclude"),
+			Severity:     severity,
+			ProblemsOnly: viper.GetBool("member-problems-only"),
+			Perfdata:     viper.GetBool("member-perfdata"),
+			Maintenance: func(Member string) string {
+				return schedule.Annotation(maintenance.Scope{
+					Device: viper.GetString("device"),
+					Pool:   viper.GetString("pool"),
+					Member: Member,
+				}, time.Now())
+			},
+		}
+		p, err = pool.NewPool(viper.GetString("index"), viper.GetString("pool"), viper.GetBool("ignore-disabled"), members, elasticsearch, nagios)
 		if err != nil {
-			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create pool check")
-			log.Fatal().Err(err).Msg("Could not create pool check")
-			nagios.Finish()
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create pool check: "+err.Error())
+			logger.Error().Str("id", "00010015").Err(err).Msg("Could not create pool check")
 			return
 		}
 		result, err := p.Execute()
@@ -78,10 +140,71 @@ var poolCmd = &cobra.Command{
 		}
 		p.Check(result, viper.GetString("warning"),
 			viper.GetString("critical"),
-			viper.GetString("age_warning"),
-			viper.GetString("age_critical"))
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		runPoolChecks(p, result, poolChecks{
+			connectionLimit:     viper.GetFloat64("connection-limit"),
+			utilizationWarning:  viper.GetString("utilization-warning"),
+			utilizationCritical: viper.GetString("utilization-critical"),
+			imbalanceMetric:     imbalance,
+			imbalanceWarning:    viper.GetString("imbalance-warning"),
+			imbalanceCritical:   viper.GetString("imbalance-critical"),
+			imbalanceIdleMean:   viper.GetFloat64("imbalance-idle-mean"),
+			window:              window,
+			unavailableWarning:  viper.GetString("unavailable-warning"),
+			unavailableCritical: viper.GetString("unavailable-critical"),
+			flapThreshold:       viper.GetInt("flap-threshold"),
+			membershipState:     viper.GetString("membership-state"),
+			membershipAccept:    viper.GetBool("membership-accept"),
+			membershipAge:       membershipAge,
+		})
 		log.Info().Msg("Check finished successfully")
-		nagios.Finish()
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device"), Pool: viper.GetString("pool")})
 		return
 	},
 }
+
+// The optional checks of a pool next to the member states, see runPoolChecks
+type poolChecks struct {
+	connectionLimit     float64
+	utilizationWarning  string
+	utilizationCritical string
+	imbalanceMetric     string
+	imbalanceWarning    string
+	imbalanceCritical   string
+	imbalanceIdleMean   float64
+	window              time.Duration
+	unavailableWarning  string
+	unavailableCritical string
+	flapThreshold       int
+	membershipState     string
+	membershipAccept    bool
+	membershipAge       time.Duration
+}
+
+// Run the optional checks of the pool which are enabled in the options. Used
+// by the pool subcommand and the pool checks of a bundle.
+func runPoolChecks(p *pool.Pool, result *pool.PoolState, o poolChecks) {
+	if o.connectionLimit > 0 || o.utilizationWarning != "" || o.utilizationCritical != "" {
+		p.CheckUtilization(result, o.connectionLimit, o.utilizationWarning, o.utilizationCritical)
+	}
+	if o.imbalanceMetric != "" {
+		p.CheckImbalance(result, o.imbalanceMetric, o.imbalanceWarning, o.imbalanceCritical, o.imbalanceIdleMean)
+	}
+	if o.window > 0 {
+		p.CheckUnavailable(o.window, o.unavailableWarning, o.unavailableCritical)
+	}
+	if o.flapThreshold > 0 {
+		flapWindow := o.window
+		if flapWindow == 0 {
+			flapWindow = time.Hour
+		}
+		p.CheckFlapping(flapWindow, o.flapThreshold)
+	}
+	if o.membershipState != "" {
+		p.CheckMembershipState(result, o.membershipState, o.membershipAccept)
+	}
+	if o.membershipAge > 0 {
+		p.CheckMembershipHistory(result, o.membershipAge)
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// The keys a connection may set
+var connectionKeys = []string{"ssl", "validatessl", "host", "port", "user", "password", "proxy", "socks", "timeout", "index"}
+
+// Matches ${VAR} and ${VAR:-default} in the values of the configuration file
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Replace the references to environment variables in the string values of
+// the parsed configuration, including those in nested maps and lists. Like in
+// the shell, unset or empty variables are replaced with the default, if one
+// is given.
+func interpolateEnv(Value interface{}) interface{} {
+	switch v := Value.(type) {
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(Reference string) string {
+			match := envReference.FindStringSubmatch(Reference)
+			if value := os.Getenv(match[1]); value != "" {
+				return value
+			}
+			return match[3]
+		})
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = interpolateEnv(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = interpolateEnv(item)
+		}
+	}
+	return Value
+}
+
+// Read the configuration file and replace the environment variables in its
+// values. The file is parsed first, so the values of the variables are never
+// interpreted as part of the file and references in comments are ignored.
+func readConfigFile(File string) error {
+	content, err := os.ReadFile(File)
+	if err != nil {
+		return err
+	}
+	ext := strings.TrimPrefix(filepath.Ext(File), ".")
+	if ext == "" {
+		return errors.New("Can't determine the format of the config file " + File + " without extension")
+	}
+	file := viper.New()
+	file.SetConfigType(ext)
+	if err = file.ReadConfig(bytes.NewReader(content)); err != nil {
+		return err
+	}
+	// Copy the top level values, AllSettings would split keys containing
+	// dots like the field names in the thresholds
+	settings := map[string]interface{}{}
+	for _, key := range file.AllKeys() {
+		top := strings.SplitN(key, ".", 2)[0]
+		if _, ok := settings[top]; !ok {
+			settings[top] = interpolateEnv(file.Get(top))
+		}
+	}
+	viper.SetConfigType(ext)
+	if err = viper.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	return renameLegacyKeys()
+}
+
+// Apply the named profile. A check profile from the map "profiles" may set
+// every key of the configuration file and refer to a connection profile from
+// the map "connections" with the key "connection". The name may also refer to
+// a connection profile directly. Flags given on the command line override the
+// values from the profile.
+func applyProfile(Name string) error {
+	logger := log.With().Str("func", "applyProfile").Str("package", "cmd").Str("profile", Name).Logger()
+	logger.Trace().Msg("Enter func")
+
+	settings := map[string]interface{}{}
+	connection := ""
+	if viper.IsSet("profiles." + Name) {
+		profile := viper.GetStringMap("profiles." + Name)
+		for key, value := range profile {
+			if key == "connection" {
+				connection = viper.GetString("profiles." + Name + ".connection")
+				continue
+			}
+			settings[key] = value
+		}
+	} else if viper.IsSet("connections." + Name) {
+		connection = Name
+	} else {
+		return errors.New("Unknown profile " + Name)
+	}
+	if connection != "" {
+		if !viper.IsSet("connections." + connection) {
+			return errors.New("Profile " + Name + " refers to the unknown connection " + connection)
+		}
+		for key, value := range viper.GetStringMap("connections." + connection) {
+			if !isConnectionKey(key) {
+				return errors.New("Unknown key " + key + " in connection " + connection)
+			}
+			if _, ok := settings[key]; !ok {
+				settings[key] = value
+			}
+		}
+	}
+	logger.Debug().Str("id", "DBG00010001").Str("connection", connection).Msg("Apply profile")
+	return viper.MergeConfigMap(settings)
+}
+
+// Check, if the key can be set by a connection
+func isConnectionKey(Key string) bool {
+	for _, k := range connectionKeys {
+		if strings.EqualFold(k, Key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterpolateEnv(t *testing.T) {
+	t.Setenv("CF5_TEST_PASSWORD", `se"cr: et`)
+	t.Setenv("CF5_TEST_EMPTY", "")
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"plain", "host", "host"},
+		{"variable", "${CF5_TEST_PASSWORD}", `se"cr: et`},
+		{"embedded", "pre-${CF5_TEST_PASSWORD}-post", `pre-se"cr: et-post`},
+		{"default", "${CF5_TEST_UNSET:-9200}", "9200"},
+		{"unset", "${CF5_TEST_UNSET}", ""},
+		{"empty uses default", "${CF5_TEST_EMPTY:-x}", "x"},
+		{"empty", "${CF5_TEST_EMPTY}", ""},
+		{"no reference", "$CF5_TEST_PASSWORD", "$CF5_TEST_PASSWORD"},
+		{"number", 9200, 9200},
+		{"nested",
+			map[string]interface{}{"es": map[string]interface{}{"password": "${CF5_TEST_PASSWORD}", "port": 9200}},
+			map[string]interface{}{"es": map[string]interface{}{"password": `se"cr: et`, "port": 9200}}},
+		{"list",
+			[]interface{}{"${CF5_TEST_UNSET:-a}", map[string]interface{}{"name": "${CF5_TEST_UNSET:-b}"}},
+			[]interface{}{"a", map[string]interface{}{"name": "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interpolateEnv(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interpolateEnv(%#v) = %#v, want %#v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
@@ -32,6 +34,10 @@ See https://clouddocs.f5.com/products/extensions/f5-telemetry-streaming/latest/
 // Global variable for cobra, storing the viper configuration file name
 var ConfigFile string
 
+// Global variable for cobra, name of the check or connection profile from the
+// configuration file
+var ConfigProfile string
+
 // Global variable for cobra, one of the zerolog log levels (TRACE, DEBUG, INFO,
 // WARN, ERROR, FATAL,PANIC). Trace produces an extreme amount of log data, use
 // with care on a small dataset
@@ -85,9 +91,191 @@ var AgeWarn string
 // Global variable for cobra, Maximum data age for a critical alert
 var AgeCrit string
 
-// Global variable for cobra, Ignore disabled pool members
+// Global variable for cobra, Ignore disabled pool and GSLB pool members
 var IgnoreDisabled bool
 
+// Global variable for cobra, only check pool members matching this regex
+var MemberInclude string
+
+// Global variable for cobra, don't check pool members matching this regex
+var MemberExclude string
+
+// Global variable for cobra, severity of unavailable pool members
+var MemberSeverity string
+
+// Global variable for cobra, only list pool members with problems
+var MemberProblemsOnly bool
+
+// Global variable for cobra, add performance data for every pool member
+var MemberPerfdata bool
+
+// Global variable for cobra, metric used to detect an imbalance between pool
+// members (connections or bits), empty to disable the imbalance check
+var ImbalanceMetric string
+
+// Global variable for cobra, warning range for the percentage above the mean
+var ImbalanceWarn string
+
+// Global variable for cobra, critical range for the percentage above the mean
+var ImbalanceCrit string
+
+// Global variable for cobra, minimum mean per member before idle members raise
+// a warning, 0 disables the warning
+var ImbalanceIdleMean float64
+
+// Global variable for cobra, connection limit of the pool, 0 uses the
+// historical maximum
+var ConnectionLimit float64
+
+// Global variable for cobra, warning range for the connection utilization
+var UtilizationWarn string
+
+// Global variable for cobra, critical range for the connection utilization
+var UtilizationCrit string
+
+// Global variable for cobra, thresholds per metric in the form
+// metric=warning,critical
+var Thresholds []string
+
+// Global variable for cobra, warn if the attack signatures are older than this
+var SignatureAgeWarn string
+
+// Global variable for cobra, critical if the attack signatures are older than
+// this
+var SignatureAgeCrit string
+
+// Global variable for cobra, only check interfaces matching this regex
+var InterfaceInclude string
+
+// Global variable for cobra, don't check interfaces matching this regex
+var InterfaceExclude string
+
+// Global variable for cobra, kind of GTM object to check (wideip or pool)
+var GtmType string
+
+// Global variable for cobra, DNS record type of the GTM objects (a or aaaa)
+var RecordType string
+
+// Global variable for cobra, regular expression matching the GTM object names
+var GtmName string
+
+// Global variable for cobra, type of the profile (http, tcp, clientssl or
+// serverssl)
+var ProfileType string
+
+// Global variable for cobra, full name of the profile
+var ProfileName string
+
+// Global variable for cobra, regular expression selecting the iRules
+var IRuleInclude string
+
+// Global variable for cobra, number of weeks for the throughput baseline (0
+// disables the baseline check)
+var BaselineWeeks int
+
+// Global variable for cobra, time slot around the current time of the week
+// used for the baseline
+var BaselineSlot string
+
+// Global variable for cobra, metrics compared with their baseline
+var BaselineMetrics []string
+
+// Global variable for cobra, allowed deviation from the baseline before a
+// warning, in standard deviations or percent
+var DeviationWarn string
+
+// Global variable for cobra, allowed deviation from the baseline before a
+// critical alert, in standard deviations or percent
+var DeviationCrit string
+
+// Global variable for cobra, modules expected to be provisioned
+var Provisioning []string
+
+// Global variable for cobra, allowed TMOS versions
+var AllowedVersions []string
+
+// Global variable for cobra, name of the device, used to restrict the
+// queries to its documents and to match the maintenance windows
+var Device string
+
+// Global variable for cobra, time window for trend checks
+var Window string
+
+// Global variable for cobra, aggregation used for trend checks (avg, min, max
+// or a percentile like p95)
+var Aggregation string
+
+// Global variable for cobra, number of availability changes of a member
+// within the window before it is considered flapping (0 disables the check)
+var FlapThreshold int
+
+// Global variable for cobra, state file storing the snapshot of the pool
+// members
+var MembershipState string
+
+// Global variable for cobra, compare the pool members with the document this
+// old
+var MembershipAge string
+
+// Global variable for cobra, store the current pool members as new snapshot
+var MembershipAccept bool
+
+// Global variable for cobra, history window for the forecast (empty disables
+// the forecast)
+var ForecastWindow string
+
+// Global variable for cobra, limit the forecast projects the time to
+var ForecastLimit string
+
+// Global variable for cobra, warn if the limit is reached within this horizon
+var ForecastWarn string
+
+// Global variable for cobra, critical if the limit is reached within this
+// horizon
+var ForecastCrit string
+
+// Global variable for cobra, metrics to forecast
+var ForecastMetrics []string
+
+// Global variable for cobra, Warning range for the percentage a pool was
+// unavailable
+var UnavailableWarn string
+
+// Global variable for cobra, Critical range for the percentage a pool was
+// unavailable
+var UnavailableCrit string
+
+// Global variable for cobra, expected poll interval of the telemetry
+var PollInterval string
+
+// Global variable for cobra, how far back to look for devices which stopped
+// sending telemetry data
+var Lookback string
+
+// Global variable for cobra, devices which must send telemetry data
+var ExpectedDevices []string
+
+// Global variable for cobra, field identifying the device
+var DeviceField string
+
+// Global variable for cobra, file the bundle appends the passive check results
+// to, e.g. the command pipe of Nagios/Icinga2
+var PassiveFile string
+
+// Global variable for cobra, don't test the connection to Elasticsearch when
+// validating the configuration
+var Offline bool
+
+// Global variable for cobra, output format of the discovery
+var Format string
+
+// Global variable for cobra, template file for the generated services
+var Template string
+
+// Global variable for cobra, name of the Icinga2 host the generated services
+// are assigned to
+var IcingaHost string
+
 // Run the checkcommand
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -96,9 +284,26 @@ func Execute() {
 	}
 }
 
+// Flag names are spelled with hyphens. The underscores of the old names like
+// --age_warning are still accepted.
+func normalizeFlagName(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	return pflag.NormalizedName(strings.ReplaceAll(name, "_", "-"))
+}
+
+// Add flags used by several, but not all subcommands. The subcommands share
+// the same flag objects, so the viper binding sees the value given to any of
+// them.
+func addSharedFlags(Flags *pflag.FlagSet, Commands ...*cobra.Command) {
+	for _, c := range Commands {
+		c.PersistentFlags().AddFlagSet(Flags)
+	}
+}
+
 // Initialize the various parameters and set defaults
 func init() {
+	rootCmd.SetGlobalNormalizationFunc(normalizeFlagName)
 	rootCmd.PersistentFlags().StringVarP(&ConfigFile, "config", "c", "", "Configuration file")
+	rootCmd.PersistentFlags().StringVar(&ConfigProfile, "profile", "", "Name of a check or connection profile from the configuration file")
 	rootCmd.PersistentFlags().StringVarP(&LogLevel, "loglevel", "l", "WARN", "Log level")
 	rootCmd.PersistentFlags().StringVarP(&LogFile, "logfile", "L", "/var/log/icinga2/check_f5_telemetry.log", "Log file (use - to log to stdout)")
 
@@ -113,15 +318,95 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&Timeout, "timeout", "T", "2m", "Timeout understood by time.ParseDuration")
 	rootCmd.PersistentFlags().StringVarP(&Warn, "warning", "W", "", "Warning range")
 	rootCmd.PersistentFlags().StringVarP(&Crit, "critical", "C", "", "Critical range")
-	rootCmd.PersistentFlags().StringVarP(&AgeWarn, "age_warning", "a", "5m", "Warn if data is older than this")
-	rootCmd.PersistentFlags().StringVarP(&AgeCrit, "age_critical", "A", "15m", "Critical if data is older than this")
+	rootCmd.PersistentFlags().StringVarP(&AgeWarn, "age-warning", "a", "5m", "Warn if data is older than this")
+	rootCmd.PersistentFlags().StringVarP(&AgeCrit, "age-critical", "A", "15m", "Critical if data is older than this")
 	rootCmd.PersistentFlags().StringVarP(&Index, "index", "I", "f5_telemetry", "Name of the index containing the f5 telemetry data")
+	rootCmd.PersistentFlags().StringVar(&Device, "device", "", "Name of the device, only its documents are used and the maintenance windows for it apply")
+	rootCmd.PersistentFlags().StringVar(&DeviceField, "device-field", "system.hostname.keyword", "Field identifying the device")
 
 	poolCmd.PersistentFlags().StringVarP(&Pool, "pool", "O", "", "Name of the pool object to check")
-	poolCmd.PersistentFlags().BoolVarP(&IgnoreDisabled, "ignore_disabled", "i", false, "Ignore disabled members")
+	poolCmd.PersistentFlags().StringVar(&MemberInclude, "member-include", "", "Only check members matching this regular expression")
+	poolCmd.PersistentFlags().StringVar(&MemberExclude, "member-exclude", "", "Don't check members matching this regular expression")
+	poolCmd.PersistentFlags().StringVar(&MemberSeverity, "member-severity", "warning", "Severity of unavailable members (warning or critical)")
+	poolCmd.PersistentFlags().BoolVar(&MemberProblemsOnly, "member-problems-only", false, "Only list members which are not ok")
+	poolCmd.PersistentFlags().BoolVar(&MemberPerfdata, "member-perfdata", false, "Add connection and traffic performance data for every member")
+	poolCmd.PersistentFlags().StringVar(&ImbalanceMetric, "imbalance-metric", "", "Check the distribution of connections or bits over the members")
+	poolCmd.PersistentFlags().StringVar(&ImbalanceWarn, "imbalance-warning", "", "Warning range for the percentage a member is above the mean")
+	poolCmd.PersistentFlags().StringVar(&ImbalanceCrit, "imbalance-critical", "", "Critical range for the percentage a member is above the mean")
+	poolCmd.PersistentFlags().Float64Var(&ImbalanceIdleMean, "imbalance-idle-mean", 0, "Warn about available members receiving nothing if the mean per member is at least this (negative disables it)")
+	poolCmd.PersistentFlags().Float64Var(&ConnectionLimit, "connection-limit", 0, "Connection limit for the utilization check (0 uses the historical maximum)")
+	poolCmd.PersistentFlags().StringVar(&UtilizationWarn, "utilization-warning", "", "Warning range for the connection utilization in percent")
+	poolCmd.PersistentFlags().StringVar(&UtilizationCrit, "utilization-critical", "", "Critical range for the connection utilization in percent")
+	poolCmd.PersistentFlags().StringVar(&UnavailableWarn, "unavailable-warning", "", "Warning range for the percentage of the window the pool was unavailable")
+	poolCmd.PersistentFlags().StringVar(&UnavailableCrit, "unavailable-critical", "", "Critical range for the percentage of the window the pool was unavailable")
+	poolCmd.PersistentFlags().IntVar(&FlapThreshold, "flap-threshold", 0, "Warn if a member changes its availability more often within the window (0 disables it, the window defaults to 1h)")
+	poolCmd.PersistentFlags().StringVar(&MembershipState, "membership-state", "", "Warn if members were added or removed since the snapshot stored in this state file")
+	poolCmd.PersistentFlags().StringVar(&MembershipAge, "membership-age", "", "Warn if members were added or removed since the document this old (e.g. 24h)")
+	poolCmd.PersistentFlags().BoolVar(&MembershipAccept, "membership-accept", false, "Store the current members as new snapshot in the membership state file")
+
+	throughputCmd.PersistentFlags().IntVar(&BaselineWeeks, "baseline-weeks", 0, "Compare with the baseline from the same time of the week over this many weeks (0 disables it)")
+	throughputCmd.PersistentFlags().StringVar(&BaselineSlot, "baseline-slot", "1h", "Time slot around the current time of the week used for the baseline")
+	throughputCmd.PersistentFlags().StringSliceVar(&BaselineMetrics, "baseline-metrics", []string{"inBits", "outBits"}, "Metrics compared with their baseline")
+	throughputCmd.PersistentFlags().StringVar(&DeviationWarn, "deviation-warning", "", "Warn if the deviation from the baseline exceeds this many standard deviations or percent (e.g. 3 or 50%)")
+	throughputCmd.PersistentFlags().StringVar(&DeviationCrit, "deviation-critical", "", "Critical if the deviation from the baseline exceeds this many standard deviations or percent (e.g. 4 or 80%)")
+
+	asmCmd.PersistentFlags().StringVar(&SignatureAgeWarn, "signature-age-warning", "", "Warn if the attack signatures are older than this (e.g. 14d)")
+	asmCmd.PersistentFlags().StringVar(&SignatureAgeCrit, "signature-age-critical", "", "Critical if the attack signatures are older than this (e.g. 30d)")
+	interfacesCmd.PersistentFlags().StringVar(&InterfaceInclude, "interface-include", "", "Only check interfaces matching this regular expression")
+	interfacesCmd.PersistentFlags().StringVar(&InterfaceExclude, "interface-exclude", "", "Don't check interfaces matching this regular expression")
+	gtmCmd.PersistentFlags().StringVar(&GtmType, "gtm-type", "wideip", "Kind of GTM object to check (wideip or pool)")
+	gtmCmd.PersistentFlags().StringVar(&RecordType, "record-type", "a", "DNS record type of the GTM objects (a or aaaa)")
+	gtmCmd.PersistentFlags().StringVar(&GtmName, "gtm-name", "", "Regular expression matching the names of the GTM objects to check")
+	profileCmd.PersistentFlags().StringVar(&ProfileType, "profile-type", "http", "Type of the profile (http, tcp, clientssl or serverssl)")
+	profileCmd.PersistentFlags().StringVar(&ProfileName, "profile-name", "", "Full name of the profile, e.g. /Common/http")
+	iruleCmd.PersistentFlags().StringVar(&IRuleInclude, "irule-include", ".*", "Regular expression selecting the iRules by their full name")
+	systemCmd.PersistentFlags().StringSliceVar(&Provisioning, "provisioning", []string{}, "Comma separated list of the modules expected to be provisioned, e.g. ltm,asm")
+	systemCmd.PersistentFlags().StringSliceVar(&AllowedVersions, "allowed-versions", []string{}, "Comma separated list of allowed TMOS versions or version prefixes, e.g. 15.1,16.1.3")
+	telemetryCmd.PersistentFlags().StringVar(&PollInterval, "poll-interval", "60s", "Expected interval between two documents of a device")
+	telemetryCmd.PersistentFlags().StringVar(&Lookback, "lookback", "24h", "Devices which sent data within this time must have sent data in the window")
+	telemetryCmd.PersistentFlags().StringSliceVar(&ExpectedDevices, "expected-devices", []string{}, "Comma separated list of the devices which must have sent data in the window")
+	configValidateCmd.PersistentFlags().BoolVar(&Offline, "offline", false, "Don't test the connection to elasticsearch and the index")
+	discoverCmd.PersistentFlags().StringVar(&Format, "format", "table", "Output format (table, json, icinga2 or director)")
+	generateServicesCmd.PersistentFlags().StringVar(&Template, "template", "", "Go template file for the services (defaults to a Service object per pool and virtual server)")
+	generateServicesCmd.PersistentFlags().StringVar(&IcingaHost, "icinga-host", "", "Name of the Icinga2 host the services are assigned to (defaults to the device)")
+	forecastFlags := pflag.NewFlagSet("forecast", pflag.ContinueOnError)
+	forecastFlags.StringVar(&ForecastWindow, "forecast-window", "", "Fit a linear trend over this history window and forecast when the limit is reached")
+	forecastFlags.StringVar(&ForecastLimit, "forecast-limit", "", "Limit for the forecast (system-status defaults to 100 percent)")
+	forecastFlags.StringVar(&ForecastWarn, "forecast-warning", "14d", "Warn if the limit is projected to be reached within this horizon")
+	forecastFlags.StringVar(&ForecastCrit, "forecast-critical", "", "Critical if the limit is projected to be reached within this horizon")
+	forecastFlags.StringSliceVar(&ForecastMetrics, "forecast-metrics", []string{}, "Metrics to forecast (defaults to inBits,outBits for throughput and memory,tmmMemory for system-status)")
+	addSharedFlags(forecastFlags, systemCmd, throughputCmd)
+	windowFlags := pflag.NewFlagSet("window", pflag.ContinueOnError)
+	windowFlags.StringVar(&Window, "window", "", "Evaluate the data of this time window instead of the latest document (telemetry defaults to 15m)")
+	addSharedFlags(windowFlags, poolCmd, throughputCmd, connectionsCmd, telemetryCmd)
+	aggregationFlags := pflag.NewFlagSet("aggregation", pflag.ContinueOnError)
+	aggregationFlags.StringVar(&Aggregation, "aggregation", "avg", "Aggregation over the window (avg, min, max, p50, p90, p95 or p99)")
+	addSharedFlags(aggregationFlags, throughputCmd, connectionsCmd)
+	ignoreDisabledFlags := pflag.NewFlagSet("ignore-disabled", pflag.ContinueOnError)
+	ignoreDisabledFlags.BoolVarP(&IgnoreDisabled, "ignore-disabled", "i", false, "Ignore disabled members")
+	addSharedFlags(ignoreDisabledFlags, poolCmd, gtmCmd)
+	thresholdFlags := pflag.NewFlagSet("threshold", pflag.ContinueOnError)
+	thresholdFlags.StringArrayVar(&Thresholds, "threshold", []string{}, "Threshold for a metric as metric=warning,critical (can be repeated)")
+	addSharedFlags(thresholdFlags, throughputCmd, connectionsCmd, asmCmd, interfacesCmd, profileCmd, iruleCmd, telemetryCmd)
+	bundleCmd.PersistentFlags().StringVar(&PassiveFile, "passive-file", "", "Append the result of every check as passive check result for the host given by --device to this file or command pipe")
 
 	rootCmd.AddCommand(poolCmd)
 	rootCmd.AddCommand(throughputCmd)
+	rootCmd.AddCommand(connectionsCmd)
+	rootCmd.AddCommand(asmCmd)
+	rootCmd.AddCommand(interfacesCmd)
+	rootCmd.AddCommand(gtmCmd)
+	rootCmd.AddCommand(profileCmd)
+	rootCmd.AddCommand(iruleCmd)
+	rootCmd.AddCommand(systemCmd)
+	rootCmd.AddCommand(telemetryCmd)
+	rootCmd.AddCommand(bundleCmd)
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(discoverCmd)
+	generateCmd.AddCommand(generateCommandsCmd)
+	generateCmd.AddCommand(generateServicesCmd)
+	rootCmd.AddCommand(generateCmd)
 
 	viper.SetDefault("loglevel", "WARN")
 	viper.SetDefault("logfile", "/var/log/icinga2/check_f5_telemetry.log")
@@ -136,12 +421,70 @@ func init() {
 	viper.SetDefault("timeout", "2m")
 	viper.SetDefault("warning", "")
 	viper.SetDefault("critical", "")
-	viper.SetDefault("age_warning", "5m")
-	viper.SetDefault("age_critical", "15m")
+	viper.SetDefault("age-warning", "5m")
+	viper.SetDefault("age-critical", "15m")
 	viper.SetDefault("index", "f5_telemetry")
+	viper.SetDefault("threshold", []string{})
+	viper.SetDefault("device", "")
+	viper.SetDefault("window", "")
+	viper.SetDefault("aggregation", "avg")
+	viper.SetDefault("forecast-window", "")
+	viper.SetDefault("forecast-limit", "")
+	viper.SetDefault("forecast-warning", "14d")
+	viper.SetDefault("forecast-critical", "")
+	viper.SetDefault("forecast-metrics", []string{})
 
 	viper.SetDefault("pool", "")
-	viper.SetDefault("ignore_disabled", "false")
+	viper.SetDefault("ignore-disabled", "false")
+	viper.SetDefault("member-include", "")
+	viper.SetDefault("member-exclude", "")
+	viper.SetDefault("member-severity", "warning")
+	viper.SetDefault("member-problems-only", false)
+	viper.SetDefault("member-perfdata", false)
+	viper.SetDefault("imbalance-metric", "")
+	viper.SetDefault("imbalance-warning", "")
+	viper.SetDefault("imbalance-critical", "")
+	viper.SetDefault("imbalance-idle-mean", 0)
+	viper.SetDefault("connection-limit", 0)
+	viper.SetDefault("utilization-warning", "")
+	viper.SetDefault("utilization-critical", "")
+	viper.SetDefault("unavailable-warning", "")
+	viper.SetDefault("unavailable-critical", "")
+	viper.SetDefault("flap-threshold", 0)
+	viper.SetDefault("membership-state", "")
+	viper.SetDefault("membership-age", "")
+	viper.SetDefault("membership-accept", false)
+
+	viper.SetDefault("baseline-weeks", 0)
+	viper.SetDefault("baseline-slot", "1h")
+	viper.SetDefault("baseline-metrics", []string{"inBits", "outBits"})
+	viper.SetDefault("deviation-warning", "")
+	viper.SetDefault("deviation-critical", "")
+
+	viper.SetDefault("signature-age-warning", "")
+	viper.SetDefault("signature-age-critical", "")
+
+	viper.SetDefault("interface-include", "")
+	viper.SetDefault("interface-exclude", "")
+
+	viper.SetDefault("gtm-type", "wideip")
+	viper.SetDefault("record-type", "a")
+	viper.SetDefault("gtm-name", "")
+
+	viper.SetDefault("profile-type", "http")
+	viper.SetDefault("profile-name", "")
+	viper.SetDefault("irule-include", ".*")
+	viper.SetDefault("provisioning", []string{})
+	viper.SetDefault("allowed-versions", []string{})
+	viper.SetDefault("poll-interval", "60s")
+	viper.SetDefault("lookback", "24h")
+	viper.SetDefault("expected-devices", []string{})
+	viper.SetDefault("device-field", "system.hostname.keyword")
+	viper.SetDefault("passive-file", "")
+	viper.SetDefault("offline", false)
+	viper.SetDefault("format", "table")
+	viper.SetDefault("template", "")
+	viper.SetDefault("icinga-host", "")
 
 	viper.BindPFlag("loglevel", rootCmd.PersistentFlags().Lookup("loglevel"))
 	viper.BindPFlag("logfile", rootCmd.PersistentFlags().Lookup("logfile"))
@@ -156,12 +499,70 @@ func init() {
 	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
 	viper.BindPFlag("warning", rootCmd.PersistentFlags().Lookup("warning"))
 	viper.BindPFlag("critical", rootCmd.PersistentFlags().Lookup("critical"))
-	viper.BindPFlag("age_warning", rootCmd.PersistentFlags().Lookup("age_warning"))
-	viper.BindPFlag("age_critical", rootCmd.PersistentFlags().Lookup("age_critical"))
+	viper.BindPFlag("age-warning", rootCmd.PersistentFlags().Lookup("age-warning"))
+	viper.BindPFlag("age-critical", rootCmd.PersistentFlags().Lookup("age-critical"))
 	viper.BindPFlag("index", rootCmd.PersistentFlags().Lookup("index"))
+	viper.BindPFlag("threshold", thresholdFlags.Lookup("threshold"))
+	viper.BindPFlag("device", rootCmd.PersistentFlags().Lookup("device"))
+	viper.BindPFlag("window", windowFlags.Lookup("window"))
+	viper.BindPFlag("aggregation", aggregationFlags.Lookup("aggregation"))
+	viper.BindPFlag("forecast-window", forecastFlags.Lookup("forecast-window"))
+	viper.BindPFlag("forecast-limit", forecastFlags.Lookup("forecast-limit"))
+	viper.BindPFlag("forecast-warning", forecastFlags.Lookup("forecast-warning"))
+	viper.BindPFlag("forecast-critical", forecastFlags.Lookup("forecast-critical"))
+	viper.BindPFlag("forecast-metrics", forecastFlags.Lookup("forecast-metrics"))
 
 	viper.BindPFlag("pool", poolCmd.PersistentFlags().Lookup("pool"))
-	viper.BindPFlag("ignore_disabled", poolCmd.PersistentFlags().Lookup("ignore_disabled"))
+	viper.BindPFlag("ignore-disabled", ignoreDisabledFlags.Lookup("ignore-disabled"))
+	viper.BindPFlag("member-include", poolCmd.PersistentFlags().Lookup("member-include"))
+	viper.BindPFlag("member-exclude", poolCmd.PersistentFlags().Lookup("member-exclude"))
+	viper.BindPFlag("member-severity", poolCmd.PersistentFlags().Lookup("member-severity"))
+	viper.BindPFlag("member-problems-only", poolCmd.PersistentFlags().Lookup("member-problems-only"))
+	viper.BindPFlag("member-perfdata", poolCmd.PersistentFlags().Lookup("member-perfdata"))
+	viper.BindPFlag("imbalance-metric", poolCmd.PersistentFlags().Lookup("imbalance-metric"))
+	viper.BindPFlag("imbalance-warning", poolCmd.PersistentFlags().Lookup("imbalance-warning"))
+	viper.BindPFlag("imbalance-critical", poolCmd.PersistentFlags().Lookup("imbalance-critical"))
+	viper.BindPFlag("imbalance-idle-mean", poolCmd.PersistentFlags().Lookup("imbalance-idle-mean"))
+	viper.BindPFlag("connection-limit", poolCmd.PersistentFlags().Lookup("connection-limit"))
+	viper.BindPFlag("utilization-warning", poolCmd.PersistentFlags().Lookup("utilization-warning"))
+	viper.BindPFlag("utilization-critical", poolCmd.PersistentFlags().Lookup("utilization-critical"))
+	viper.BindPFlag("unavailable-warning", poolCmd.PersistentFlags().Lookup("unavailable-warning"))
+	viper.BindPFlag("unavailable-critical", poolCmd.PersistentFlags().Lookup("unavailable-critical"))
+	viper.BindPFlag("flap-threshold", poolCmd.PersistentFlags().Lookup("flap-threshold"))
+	viper.BindPFlag("membership-state", poolCmd.PersistentFlags().Lookup("membership-state"))
+	viper.BindPFlag("membership-age", poolCmd.PersistentFlags().Lookup("membership-age"))
+	viper.BindPFlag("membership-accept", poolCmd.PersistentFlags().Lookup("membership-accept"))
+
+	viper.BindPFlag("baseline-weeks", throughputCmd.PersistentFlags().Lookup("baseline-weeks"))
+	viper.BindPFlag("baseline-slot", throughputCmd.PersistentFlags().Lookup("baseline-slot"))
+	viper.BindPFlag("baseline-metrics", throughputCmd.PersistentFlags().Lookup("baseline-metrics"))
+	viper.BindPFlag("deviation-warning", throughputCmd.PersistentFlags().Lookup("deviation-warning"))
+	viper.BindPFlag("deviation-critical", throughputCmd.PersistentFlags().Lookup("deviation-critical"))
+
+	viper.BindPFlag("signature-age-warning", asmCmd.PersistentFlags().Lookup("signature-age-warning"))
+	viper.BindPFlag("signature-age-critical", asmCmd.PersistentFlags().Lookup("signature-age-critical"))
+
+	viper.BindPFlag("interface-include", interfacesCmd.PersistentFlags().Lookup("interface-include"))
+	viper.BindPFlag("interface-exclude", interfacesCmd.PersistentFlags().Lookup("interface-exclude"))
+
+	viper.BindPFlag("gtm-type", gtmCmd.PersistentFlags().Lookup("gtm-type"))
+	viper.BindPFlag("record-type", gtmCmd.PersistentFlags().Lookup("record-type"))
+	viper.BindPFlag("gtm-name", gtmCmd.PersistentFlags().Lookup("gtm-name"))
+
+	viper.BindPFlag("profile-type", profileCmd.PersistentFlags().Lookup("profile-type"))
+	viper.BindPFlag("profile-name", profileCmd.PersistentFlags().Lookup("profile-name"))
+	viper.BindPFlag("irule-include", iruleCmd.PersistentFlags().Lookup("irule-include"))
+	viper.BindPFlag("provisioning", systemCmd.PersistentFlags().Lookup("provisioning"))
+	viper.BindPFlag("allowed-versions", systemCmd.PersistentFlags().Lookup("allowed-versions"))
+	viper.BindPFlag("poll-interval", telemetryCmd.PersistentFlags().Lookup("poll-interval"))
+	viper.BindPFlag("lookback", telemetryCmd.PersistentFlags().Lookup("lookback"))
+	viper.BindPFlag("expected-devices", telemetryCmd.PersistentFlags().Lookup("expected-devices"))
+	viper.BindPFlag("device-field", rootCmd.PersistentFlags().Lookup("device-field"))
+	viper.BindPFlag("passive-file", bundleCmd.PersistentFlags().Lookup("passive-file"))
+	viper.BindPFlag("offline", configValidateCmd.PersistentFlags().Lookup("offline"))
+	viper.BindPFlag("format", discoverCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("template", generateServicesCmd.PersistentFlags().Lookup("template"))
+	viper.BindPFlag("icinga-host", generateServicesCmd.PersistentFlags().Lookup("icinga-host"))
 
 	viper.SetEnvPrefix("cf5")
 	viper.BindEnv("password")
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	// "os"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/system"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "check" to execute a check. This is called by Nagios/Icinga2
+var systemCmd = &cobra.Command{
+	Use:   "system-status",
+	Short: "Check license and provisioning",
+	Long:  `Check F5 license, module provisioning and TMOS version based on telemetry data stored in elasticsearch`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var s *system.System
+		logger := log.With().Str("func", "system.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020009").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		forecastOptions, err := parseForecast(system.DefaultForecastLimit)
+		if err != nil {
+			logger.Error().Str("id", "00010039").Err(err).Msg("Invalid forecast")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Invalid forecast: "+err.Error())
+			return
+		}
+		forecastMetrics, err := system.ForecastMetrics(viper.GetStringSlice("forecast-metrics"))
+		if err != nil {
+			logger.Error().Str("id", "00010041").Err(err).Msg("Invalid forecast metrics")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010069").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		s, err = system.NewSystem(viper.GetString("index"),
+			viper.GetStringSlice("provisioning"),
+			viper.GetStringSlice("allowed-versions"),
+			elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create system status check: "+err.Error())
+			logger.Error().Str("id", "00010024").Err(err).Msg("Could not create system status check")
+			return
+		}
+		result, err := s.Execute()
+		if err != nil {
+			return
+		}
+		s.Check(result,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		if forecastOptions != nil {
+			runForecast(forecastOptions, forecastMetrics, elasticsearch, nagios)
+		}
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
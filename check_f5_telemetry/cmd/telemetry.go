@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	// "os"
+
+	"github.com/joernott/nagiosplugin/v2"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/telemetry"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// The subcommand "check" to execute a check. This is called by Nagios/Icinga2
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Check the telemetry pipeline",
+	Long:  `Check whether the F5 devices send telemetry data to elasticsearch in the expected interval`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		err := HandleConfigFile()
+		if err != nil {
+			fmt.Println("Config error")
+			panic(err)
+		}
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		var t *telemetry.Telemetry
+		logger := log.With().Str("func", "telemetry.Run").Str("package", "cmd").Logger()
+		logger.Trace().Msg("Enter func")
+
+		nagios := nagiosplugin.NewCheck()
+		nagios.SetVerbosity(nagiosplugin.VERBOSITY_MULTI_LINE)
+		defer nagios.Finish()
+
+		parsedTimeout, err := parseTimeout(viper.GetString("timeout"))
+		if err != nil {
+			logger.Error().Str("id", "00020010").Err(err).Msg("Could not parse timeout")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse timeout")
+			return
+		}
+
+		w := viper.GetString("window")
+		if w == "" {
+			w = "15m"
+		}
+		window, err := units.ParseDuration(w)
+		if err != nil {
+			logger.Error().Str("id", "00010025").Err(err).Msg("Could not parse window")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse window")
+			return
+		}
+		interval, err := units.ParseDuration(viper.GetString("poll-interval"))
+		if err != nil {
+			logger.Error().Str("id", "00010026").Err(err).Msg("Could not parse poll interval")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse poll interval")
+			return
+		}
+		lookback, err := units.ParseDuration(viper.GetString("lookback"))
+		if err != nil {
+			logger.Error().Str("id", "00010062").Err(err).Msg("Could not parse lookback")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse lookback")
+			return
+		}
+		expected := viper.GetStringSlice("expected-devices")
+		if viper.GetString("device") != "" {
+			expected = append(expected, viper.GetString("device"))
+		}
+
+		var config map[string]threshold.Threshold
+		if err = viper.UnmarshalKey("telemetry.thresholds", &config); err != nil {
+			logger.Error().Str("id", "00010027").Err(err).Msg("Could not read thresholds from config")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read thresholds from config")
+			return
+		}
+		thresholds, err := telemetry.MergeThresholds(viper.GetString("warning"),
+			viper.GetString("critical"),
+			config,
+			viper.GetStringSlice("threshold"),
+			interval)
+		if err != nil {
+			logger.Error().Str("id", "00010028").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010070").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
+		elasticsearch, err := elasticsearch.NewElasticsearch(
+			viper.GetBool("ssl"),
+			viper.GetString("host"),
+			viper.GetInt("port"),
+			viper.GetString("user"),
+			viper.GetString("password"),
+			viper.GetBool("validatessl"),
+			viper.GetString("proxy"),
+			viper.GetBool("socks"),
+			parsedTimeout,
+		)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create connection to Elasticsearch")
+			log.Fatal().Err(err).Msg("Could not create connection to Elasticsearch")
+			nagios.Finish()
+			return
+		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
+
+		t, err = telemetry.NewTelemetry(viper.GetString("index"),
+			window,
+			interval,
+			lookback,
+			viper.GetString("device-field"),
+			expected,
+			elasticsearch, nagios)
+		if err != nil {
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create telemetry check: "+err.Error())
+			logger.Error().Str("id", "00010029").Err(err).Msg("Could not create telemetry check")
+			return
+		}
+		result, err := t.Execute()
+		if err != nil {
+			return
+		}
+		t.Check(result, thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		log.Info().Msg("Check finished successfully")
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
+		return
+	},
+}
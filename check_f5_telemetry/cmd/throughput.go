@@ -2,12 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
+	"time"
 	// "os"
 
 	"github.com/joernott/nagiosplugin/v2"
 
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/maintenance"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/throughput"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -42,6 +47,79 @@ var throughputCmd = &cobra.Command{
 			return
 		}
 
+		var config map[string]threshold.Threshold
+		if err = viper.UnmarshalKey(throughputThresholdsKey(), &config); err != nil {
+			logger.Error().Str("id","00010004").Err(err).Msg("Could not read thresholds from config")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read thresholds from config")
+			return
+		}
+		thresholds, err := throughput.MergeThresholds(viper.GetString("warning"),
+			viper.GetString("critical"),
+			config,
+			viper.GetStringSlice("threshold"))
+		if err != nil {
+			logger.Error().Str("id","00010005").Err(err).Msg("Invalid threshold")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		window, err := parseTrend(viper.GetString("window"), viper.GetString("aggregation"))
+		if err != nil {
+			logger.Error().Str("id", "00010030").Err(err).Msg("Invalid window or aggregation")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		var slot time.Duration
+		var deviationWarn, deviationCrit *throughput.Deviation
+		baselineMetrics := []string{}
+		if viper.GetInt("baseline-weeks") > 0 {
+			if slot, err = units.ParseDuration(viper.GetString("baseline-slot")); err != nil || slot <= 0 {
+				logger.Error().Str("id", "00010033").Err(err).Msg("Could not parse baseline slot")
+				nagios.AddResult(nagiosplugin.UNKNOWN, "Could not parse baseline slot")
+				return
+			}
+			for _, m := range viper.GetStringSlice("baseline-metrics") {
+				metric, ok := throughput.MetricName(strings.TrimSpace(m))
+				if !ok {
+					logger.Error().Str("id", "00010034").Str("metric", m).Msg("Unknown baseline metric")
+					nagios.AddResult(nagiosplugin.UNKNOWN, "Unknown baseline metric "+m)
+					return
+				}
+				baselineMetrics = append(baselineMetrics, metric)
+			}
+			if deviationWarn, err = throughput.ParseDeviation(viper.GetString("deviation-warning")); err != nil {
+				logger.Error().Str("id", "00010035").Err(err).Msg("Invalid deviation warning")
+				nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+				return
+			}
+			if deviationCrit, err = throughput.ParseDeviation(viper.GetString("deviation-critical")); err != nil {
+				logger.Error().Str("id", "00010036").Err(err).Msg("Invalid deviation critical")
+				nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+				return
+			}
+		}
+
+		forecastOptions, err := parseForecast("")
+		if err != nil {
+			logger.Error().Str("id", "00010038").Err(err).Msg("Invalid forecast")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Invalid forecast: "+err.Error())
+			return
+		}
+		forecastMetrics, err := throughput.ForecastMetrics(viper.GetStringSlice("forecast-metrics"))
+		if err != nil {
+			logger.Error().Str("id", "00010040").Err(err).Msg("Invalid forecast metrics")
+			nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+			return
+		}
+
+		schedule, err := loadMaintenance()
+		if err != nil {
+			logger.Error().Str("id", "00010071").Err(err).Msg("Could not read maintenance windows")
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not read maintenance windows: "+err.Error())
+			return
+		}
+
 		elasticsearch, err := elasticsearch.NewElasticsearch(
 			viper.GetBool("ssl"),
 			viper.GetString("host"),
@@ -59,24 +137,42 @@ var throughputCmd = &cobra.Command{
 			nagios.Finish()
 			return
 		}
+		elasticsearch.SetDevice(viper.GetString("device-field"), viper.GetString("device"))
 
 		t, err = throughput.NewThroughput(viper.GetString("index"), elasticsearch, nagios)
 		if err != nil {
-			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create throughput check")
-			log.Fatal().Err(err).Msg("Could not create throughput check")
-			nagios.Finish()
+			nagios.AddResult(nagiosplugin.UNKNOWN, "Could not create throughput check: "+err.Error())
+			logger.Error().Str("id", "00010061").Err(err).Msg("Could not create throughput check")
 			return
 		}
-		err = t.Execute()
+		if window > 0 {
+			err = t.ExecuteTrend(window, viper.GetString("aggregation"))
+		} else {
+			err = t.Execute()
+		}
 		if err != nil {
 			return
 		}
-		t.Check(viper.GetString("warning"),
-			viper.GetString("critical"),
-			viper.GetString("age_warning"),
-			viper.GetString("age_critical"))
+		t.Check(thresholds,
+			viper.GetString("age-warning"),
+			viper.GetString("age-critical"))
+		if viper.GetInt("baseline-weeks") > 0 {
+			t.CheckBaseline(viper.GetInt("baseline-weeks"), slot, baselineMetrics, deviationWarn, deviationCrit)
+		}
+		if forecastOptions != nil {
+			runForecast(forecastOptions, forecastMetrics, elasticsearch, nagios)
+		}
 		log.Info().Msg("Check finished successfully")
-		nagios.Finish()
+		finish(nagios, schedule, maintenance.Scope{Device: viper.GetString("device")})
 		return
 	},
 }
+
+// The key of the threshold map for the throughput in the configuration file.
+// Older configuration files use the top level key "thresholds".
+func throughputThresholdsKey() string {
+	if !viper.IsSet("throughput.thresholds") && viper.IsSet("thresholds") {
+		return "thresholds"
+	}
+	return "throughput.thresholds"
+}
@@ -0,0 +1,288 @@
+package connections
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// These are the metrics from system.connectionsPerformance and the SSL
+// transactions per second calculated from the client SSL profiles
+var MetricFields = [...]string{
+	"clientConnections",
+	"serverConnections",
+	"newClientConnections",
+	"newServerConnections",
+	"httpRequests",
+	SSLTps}
+
+// The SSL transactions per second, the rate of new connections over all
+// client SSL profiles between the two latest documents. It is not available
+// for trend checks.
+const SSLTps = "sslTps"
+
+// Units of the metrics, the first unit is used for the performance data, the
+// second one for the text output. Like the packet rates of the throughput,
+// the rates per second use the unit "c", the current connections have none.
+var MetricUnits = map[string][2]string{
+	"clientConnections":    {"", "connections"},
+	"serverConnections":    {"", "connections"},
+	"newClientConnections": {"c", "conn/s"},
+	"newServerConnections": {"c", "conn/s"},
+	"httpRequests":         {"c", "req/s"},
+	SSLTps:                 {"c", "TPS"},
+}
+
+// Where we store the metrics in a Connections element
+type MetricData map[string]float64
+
+// The Connections object created and initialized by NewConnections
+// consolidates the connection to Elasticsearch, the nagios object and index
+// name needed to run the check.
+type Connections struct {
+	index      string
+	connection *elasticsearch.Elasticsearch
+	nagios     *nagiosplugin.Check
+	trend      string
+	Timestamp  time.Time  `yaml:"Timestamp" json:"Timestamp"`
+	Fields     MetricData `yaml:"Fields" json:"Fields"`
+}
+
+// Creates a Connections object containing the connection object to
+// Elasticsearch, a Nagios object and the Index
+func NewConnections(Index string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Connections, error) {
+	var c *Connections
+
+	logger := log.With().Str("func", "NewConnections").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+	c = new(Connections)
+	c.index = Index
+	c.connection = Connection
+	c.nagios = Nagios
+	c.Fields = make(MetricData)
+	return c, nil
+}
+
+// Execute the query, fetching the two latest documents to calculate the SSL
+// transactions per second
+func (c *Connections) Execute() error {
+	logger := log.With().Str("func", "Execute").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+	query := "{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + c.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"system.connectionsPerformance.*.current\"," +
+		"\"clientSslProfiles.*.totNativeConns\",\"clientSslProfiles.*.totCompatConns\"],\"_source\":false}"
+	data, err := c.connection.Search(c.index, query)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR30020001").
+			Str("query", query).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		c.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, c.index, query))
+		return err
+	}
+	return c.gatherConnectionsData(data)
+}
+
+// Read the metrics and the SSL TPS from the two latest documents, see Execute
+func (c *Connections) Gather(e *elasticsearch.ElasticsearchResult) error {
+	return c.gatherConnectionsData(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (c *Connections) gatherConnectionsData(e *elasticsearch.ElasticsearchResult) error {
+	var fields elasticsearch.HitElement
+	logger := log.With().Str("func", "gatherConnectionsData").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) == 0 {
+		fields = make(elasticsearch.HitElement)
+	} else {
+		fields = e.Hits.Hits[0].Fields
+	}
+
+	if len(fields) == 0 {
+		c.nagios.AddResult(nagiosplugin.UNKNOWN, "No data for connections check")
+		logger.Error().Str("id", "ERR30030001").
+			Msg("No data for connections check")
+		return errors.New("No data for connections check")
+	}
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		c.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR30030002").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return err
+	}
+	c.Timestamp = ts
+	for _, f := range MetricFields {
+		if f == SSLTps {
+			continue
+		}
+		logger.Trace().Str("id", "DBG30030001").Str("field", f).Msg("Processing field")
+		if fields["system.connectionsPerformance."+f+".current"] != nil {
+			c.Fields[f] = fields["system.connectionsPerformance."+f+".current"].([]interface{})[0].(float64)
+		} else {
+			logger.Warn().Str("id", "WRN30030001").
+				Str("field", f).
+				Msg("Field is missing")
+		}
+	}
+	if len(c.Fields) == 0 {
+		logger.Error().Str("id", "ERR30030003").Msg("No connection metrics in telemetry data")
+		c.nagios.AddResult(nagiosplugin.UNKNOWN, "No connection metrics in telemetry data")
+		return errors.New("No connection metrics in telemetry data")
+	}
+	c.gatherSSLTps(e)
+	return nil
+}
+
+// Calculate the SSL transactions per second from the connection counters of
+// the client SSL profiles in the two latest documents. The metric is left out
+// if there is no previous document, no client SSL profile or the counters
+// have been reset.
+func (c *Connections) gatherSSLTps(e *elasticsearch.ElasticsearchResult) {
+	logger := log.With().Str("func", "gatherSSLTps").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) < 2 {
+		logger.Debug().Str("id", "DBG30080001").Msg("Only one document, can't calculate SSL TPS")
+		return
+	}
+	current, found := sslConnections(e.Hits.Hits[0].Fields)
+	if !found {
+		logger.Debug().Str("id", "DBG30080002").Msg("No client SSL profiles in telemetry data")
+		return
+	}
+	previous, _ := sslConnections(e.Hits.Hits[1].Fields)
+	t := ""
+	if v, ok := e.Hits.Hits[1].Fields["@timestamp"].([]interface{}); ok && len(v) > 0 {
+		t = fmt.Sprintf("%v", v[0])
+	}
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z", t)
+	if err != nil {
+		logger.Warn().Str("id", "WRN30080001").Str("value", t).Err(err).Msg("Could not parse timestamp of the previous document")
+		return
+	}
+	seconds := c.Timestamp.Sub(ts).Seconds()
+	if seconds <= 0 || current < previous {
+		logger.Info().Str("id", "INF30080001").Msg("Counters have been reset, can't calculate SSL TPS")
+		return
+	}
+	c.Fields[SSLTps] = (current - previous) / seconds
+}
+
+// The sum of the native and compatible connections over all client SSL
+// profiles. The second return value is false if there are no such counters.
+func sslConnections(fields elasticsearch.HitElement) (float64, bool) {
+	var sum float64
+	found := false
+	for name, value := range fields {
+		if !strings.HasPrefix(name, "clientSslProfiles.") ||
+			!(strings.HasSuffix(name, ".totNativeConns") || strings.HasSuffix(name, ".totCompatConns")) {
+			continue
+		}
+		if v, ok := value.([]interface{}); ok && len(v) > 0 {
+			if f, ok := v[0].(float64); ok {
+				sum += f
+				found = true
+			}
+		}
+	}
+	return sum, found
+}
+
+// Find the metric in MetricFields, ignoring the case as viper converts all
+// keys in the configuration file to lower case
+func MetricName(Name string) (string, bool) {
+	for _, f := range MetricFields {
+		if strings.EqualFold(f, Name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to clientConnections and
+// serverConnections, unless they have their own thresholds.
+func MergeThresholds(Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string) (threshold.Thresholds, error) {
+	return threshold.Merge(Warn, Crit, []string{"clientConnections", "serverConnections"}, Config, Definitions, MetricName)
+}
+
+// Check whether we have reached any thresholds. Every metric with a threshold
+// is evaluated.
+func (c *Connections) Check(Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	for _, f := range MetricFields {
+		th, found := Thresholds[f]
+		if !found {
+			continue
+		}
+		v, found := c.Fields[f]
+		if !found {
+			c.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: %v has a threshold but is missing in the telemetry data", f))
+			ok = false
+			continue
+		}
+		logger.Debug().Str("id", "DBG30050001").
+			Str("field", f).
+			Float64("value", v).
+			Str("warning", th.Warning).
+			Str("critical", th.Critical).
+			Msg("Check threshold")
+		if threshold.CheckRange(c.nagios, th.Critical, v, "critical") {
+			c.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v %v is outside the critical range %v", f, c.format(f), th.Critical))
+			ok = false
+		} else if threshold.CheckRange(c.nagios, th.Warning, v, "warning") {
+			c.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v %v is outside the warning range %v", f, c.format(f), th.Warning))
+			ok = false
+		}
+	}
+
+	if ok {
+		c.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v client and %v server connections are within thresholds", c.format("clientConnections"), c.format("serverConnections")))
+	}
+	c.checkAddPerfdata(Thresholds)
+}
+
+// add performance data for all metrics found to the nagios output
+func (c *Connections) checkAddPerfdata(Thresholds threshold.Thresholds) {
+	for _, f := range MetricFields {
+		v, found := c.Fields[f]
+		if !found {
+			continue
+		}
+		th := Thresholds[f]
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(v)
+		c.nagios.AddPerfDatum(f, MetricUnits[f][0], p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+	}
+}
+
+// Human readable value of a metric, scaled to the appropriate SI prefix. For
+// trend checks, the aggregation is appended.
+func (c *Connections) format(Field string) string {
+	if c.trend != "" {
+		return units.Format(c.Fields[Field], MetricUnits[Field][1]) + " (" + c.trend + ")"
+	}
+	return units.Format(c.Fields[Field], MetricUnits[Field][1])
+}
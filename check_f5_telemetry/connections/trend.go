@@ -0,0 +1,40 @@
+package connections
+
+import (
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Execute an aggregation query over the last Window instead of reading the
+// latest document. The metrics are the result of the Aggregation (avg, min,
+// max or a percentile like p95), so a single spike does not raise an alert.
+func (c *Connections) ExecuteTrend(Window time.Duration, Aggregation string) error {
+	logger := log.With().Str("func", "ExecuteTrend").Str("package", "connections").Logger()
+	logger.Trace().Msg("Enter func")
+
+	fields := make(map[string]string)
+	for _, f := range MetricFields {
+		if f == SSLTps {
+			continue
+		}
+		fields[f] = "system.connectionsPerformance." + f + ".current"
+	}
+	c.Timestamp = time.Now()
+	values, err := trend.Execute(c.connection, c.index, Window, Aggregation, fields)
+	if err != nil {
+		logger.Error().Str("id", "ERR30070001").
+			Str("window", Window.String()).
+			Err(err).
+			Msg("Could not aggregate the window")
+		c.nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+		return err
+	}
+	for f, value := range values {
+		c.Fields[f] = value
+	}
+	c.trend = trend.Describe(Aggregation, Window)
+	return nil
+}
@@ -0,0 +1,228 @@
+// package discovery lists the objects found in the telemetry data, which can
+// be monitored by the other subcommands
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/pool"
+	"github.com/rs/zerolog/log"
+)
+
+// A pool and its members
+type PoolInfo struct {
+	Name              string   `yaml:"Name" json:"Name"`
+	AvailabilityState string   `yaml:"AvailabilityState" json:"AvailabilityState"`
+	Members           []string `yaml:"Members" json:"Members"`
+}
+
+// A virtual server with its destination and default pool
+type VirtualServerInfo struct {
+	Name              string `yaml:"Name" json:"Name"`
+	Destination       string `yaml:"Destination" json:"Destination"`
+	Pool              string `yaml:"Pool" json:"Pool"`
+	AvailabilityState string `yaml:"AvailabilityState" json:"AvailabilityState"`
+	EnabledState      string `yaml:"EnabledState" json:"EnabledState"`
+}
+
+// A network interface and its status
+type InterfaceInfo struct {
+	Name   string `yaml:"Name" json:"Name"`
+	Status string `yaml:"Status" json:"Status"`
+}
+
+// A device sending telemetry data and the number of its documents
+type DeviceInfo struct {
+	Name      string `yaml:"Name" json:"Name"`
+	Documents int64  `yaml:"Documents" json:"Documents"`
+}
+
+// The objects found in the newest document. Devices contains all devices
+// found in the index, the other lists the objects of Device.
+type Inventory struct {
+	Timestamp      time.Time           `yaml:"Timestamp" json:"Timestamp"`
+	Device         string              `yaml:"Device" json:"Device"`
+	Devices        []DeviceInfo        `yaml:"Devices" json:"Devices"`
+	Pools          []PoolInfo          `yaml:"Pools" json:"Pools"`
+	VirtualServers []VirtualServerInfo `yaml:"VirtualServers" json:"VirtualServers"`
+	Interfaces     []InterfaceInfo     `yaml:"Interfaces" json:"Interfaces"`
+}
+
+// The Discovery object created and initialized by NewDiscovery consolidates
+// the connection to Elasticsearch, the index name and the device field
+// needed to read the inventory.
+type Discovery struct {
+	index       string
+	deviceField string
+	device      string
+	connection  *elasticsearch.Elasticsearch
+}
+
+// Creates a Discovery object containing the connection object to
+// Elasticsearch, the Index and the field identifying the device. If Device is
+// set, the newest document of this device is used, otherwise the newest
+// document in the index.
+func NewDiscovery(Index string, DeviceField string, Device string, Connection *elasticsearch.Elasticsearch) (*Discovery, error) {
+	var d *Discovery
+
+	logger := log.With().Str("func", "NewDiscovery").Str("package", "discovery").Logger()
+	logger.Trace().Msg("Enter func")
+	if DeviceField == "" {
+		logger.Error().Str("id", "ERR13001001").Msg("No device field")
+		return nil, errors.New("The device field must not be empty")
+	}
+	d = new(Discovery)
+	d.index = Index
+	d.deviceField = DeviceField
+	d.device = Device
+	d.connection = Connection
+	return d, nil
+}
+
+// Execute the query
+func (d *Discovery) Execute() (*Inventory, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "discovery").Logger()
+	logger.Trace().Msg("Enter func")
+
+	field, _ := json.Marshal(d.deviceField)
+	query := "{\"match_all\":{}}"
+	if d.device != "" {
+		device, _ := json.Marshal(d.device)
+		query = fmt.Sprintf("{\"term\":{%s:%s}}", field, device)
+	}
+	q := fmt.Sprintf("{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":%v,\"fields\":[\"*\"],\"_source\":false,"+
+		"\"aggs\":{\"devices\":{\"terms\":{\"field\":%s,\"size\":1000}}}}", query, field)
+	data, err := d.connection.Search(d.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR13002001").
+			Str("query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		return nil, fmt.Errorf("%v. Could not run search on index %v", err, d.index)
+	}
+	return d.gatherInventory(data)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (d *Discovery) gatherInventory(e *elasticsearch.ElasticsearchResult) (*Inventory, error) {
+	logger := log.With().Str("func", "gatherInventory").Str("package", "discovery").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) == 0 || len(e.Hits.Hits[0].Fields) == 0 {
+		logger.Error().Str("id", "ERR13003001").Msg("No telemetry data found")
+		return nil, errors.New("No telemetry data found")
+	}
+	fields := e.Hits.Hits[0].Fields
+	i := new(Inventory)
+	f := "2006-01-02T15:04:05.000Z"
+	t := fieldString(fields, "@timestamp")
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		logger.Error().Str("id", "ERR13003002").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, err
+	}
+	i.Timestamp = ts
+	i.Device = fieldString(fields, d.deviceField)
+	i.Devices = gatherDevices(e)
+
+	i.Pools = make([]PoolInfo, 0)
+	for _, name := range pool.PoolNames(fields) {
+		members, err := pool.MemberNames(fields, name)
+		if err != nil {
+			logger.Error().Str("id", "ERR13003003").Str("pool", name).Err(err).Msg("Could not list pool members")
+			return nil, err
+		}
+		i.Pools = append(i.Pools, PoolInfo{
+			Name:              name,
+			AvailabilityState: fieldString(fields, "pools."+name+".availabilityState.keyword"),
+			Members:           members,
+		})
+	}
+
+	i.VirtualServers = make([]VirtualServerInfo, 0)
+	for _, name := range objectNames(fields, "virtualServers.", ".availabilityState.keyword") {
+		prefix := "virtualServers." + name + "."
+		i.VirtualServers = append(i.VirtualServers, VirtualServerInfo{
+			Name:              name,
+			Destination:       fieldString(fields, prefix+"destination.keyword"),
+			Pool:              fieldString(fields, prefix+"pool.keyword"),
+			AvailabilityState: fieldString(fields, prefix+"availabilityState.keyword"),
+			EnabledState:      fieldString(fields, prefix+"enabledState.keyword"),
+		})
+	}
+
+	i.Interfaces = make([]InterfaceInfo, 0)
+	for _, name := range objectNames(fields, "networkInterfaces.", ".status.keyword") {
+		i.Interfaces = append(i.Interfaces, InterfaceInfo{
+			Name:   name,
+			Status: fieldString(fields, "networkInterfaces."+name+".status.keyword"),
+		})
+	}
+	logger.Debug().Str("id", "DBG13003001").
+		Str("device", i.Device).
+		Int("pools", len(i.Pools)).
+		Int("virtual_servers", len(i.VirtualServers)).
+		Int("interfaces", len(i.Interfaces)).
+		Msg("Inventory gathered")
+	return i, nil
+}
+
+// The devices from the terms aggregation, sorted by name
+func gatherDevices(e *elasticsearch.ElasticsearchResult) []DeviceInfo {
+	devices := make([]DeviceInfo, 0)
+	buckets, ok := e.Aggregations["devices"]["buckets"].([]interface{})
+	if !ok {
+		return devices
+	}
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var device DeviceInfo
+		device.Name = fmt.Sprintf("%v", bucket["key"])
+		if count, ok := bucket["doc_count"].(float64); ok {
+			device.Documents = int64(count)
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(a, b int) bool { return devices[a].Name < devices[b].Name })
+	return devices
+}
+
+// The names of the objects with fields like prefix + name + suffix, sorted
+// alphabetically
+func objectNames(fields elasticsearch.HitElement, Prefix string, Suffix string) []string {
+	names := make([]string, 0)
+	for f := range fields {
+		if strings.HasPrefix(f, Prefix) && strings.HasSuffix(f, Suffix) {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(f, Prefix), Suffix))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get a field as string, missing fields are returned as empty string
+func fieldString(fields elasticsearch.HitElement, Field string) string {
+	if v, ok := fields[Field].([]interface{}); ok && len(v) > 0 {
+		return fmt.Sprintf("%v", v[0])
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// The output formats of an inventory
+var Formats = []string{"table", "json", "icinga2", "director"}
+
+// Write the inventory in the given format
+func (i *Inventory) Write(w io.Writer, Format string) error {
+	switch Format {
+	case "table":
+		return i.Table(w)
+	case "json":
+		return i.JSON(w)
+	case "icinga2":
+		return i.Icinga2(w)
+	case "director":
+		return i.Director(w)
+	}
+	return fmt.Errorf("Unknown format %v, use one of %v", Format, strings.Join(Formats, ", "))
+}
+
+// Write the inventory as human readable tables
+func (i *Inventory) Table(w io.Writer) error {
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(t, "Newest document from %v at %v\n\n", i.Device, i.Timestamp.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintln(t, "DEVICE\tDOCUMENTS")
+	for _, d := range i.Devices {
+		fmt.Fprintf(t, "%v\t%v\n", d.Name, d.Documents)
+	}
+	fmt.Fprintln(t, "\nPOOL\tSTATE\tMEMBERS")
+	for _, p := range i.Pools {
+		fmt.Fprintf(t, "%v\t%v\t%v\n", p.Name, p.AvailabilityState, strings.Join(p.Members, ", "))
+	}
+	fmt.Fprintln(t, "\nVIRTUAL SERVER\tSTATE\tDESTINATION\tPOOL")
+	for _, v := range i.VirtualServers {
+		fmt.Fprintf(t, "%v\t%v\t%v\t%v\n", v.Name, v.AvailabilityState, v.Destination, v.Pool)
+	}
+	fmt.Fprintln(t, "\nINTERFACE\tSTATUS")
+	for _, n := range i.Interfaces {
+		fmt.Fprintf(t, "%v\t%v\n", n.Name, n.Status)
+	}
+	return t.Flush()
+}
+
+// Write the inventory as JSON
+func (i *Inventory) JSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(i)
+}
+
+// Write apply rules for Icinga2. Every pool and interface gets its own
+// service using the service templates st_f5_telemetry_pool and
+// st_f5_telemetry_interfaces, assigned to the hosts with the variable
+// cf5_device set to the device. Virtual servers have no check of their own,
+// they are listed as comments.
+func (i *Inventory) Icinga2(w io.Writer) error {
+	device := IcingaString(i.Device)
+	for _, p := range i.Pools {
+		fmt.Fprintf(w, "apply Service %v {\n", IcingaString("f5_pool_"+ObjectName(p.Name)))
+		fmt.Fprintf(w, "  import \"st_f5_telemetry_pool\"\n\n")
+		fmt.Fprintf(w, "  display_name = %v\n", IcingaString("Pool "+p.Name))
+		fmt.Fprintf(w, "  vars.cf5_pool = %v\n", IcingaString(p.Name))
+		fmt.Fprintf(w, "  assign where host.vars.cf5_device == %v\n}\n\n", device)
+	}
+	for _, n := range i.Interfaces {
+		fmt.Fprintf(w, "apply Service %v {\n", IcingaString("f5_interface_"+ObjectName(n.Name)))
+		fmt.Fprintf(w, "  import \"st_f5_telemetry_interfaces\"\n\n")
+		fmt.Fprintf(w, "  display_name = %v\n", IcingaString("Interface "+n.Name))
+		fmt.Fprintf(w, "  vars.cf5_interface_include = %v\n", IcingaString("^"+regexp.QuoteMeta(n.Name)+"$"))
+		fmt.Fprintf(w, "  assign where host.vars.cf5_device == %v\n}\n\n", device)
+	}
+	for _, v := range i.VirtualServers {
+		fmt.Fprintf(w, "// Virtual server %v (%v) uses the pool %v\n", v.Name, v.Destination, v.Pool)
+	}
+	return nil
+}
+
+// A row of the director import
+type directorRow struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	ObjectName  string `json:"object_name"`
+	Device      string `json:"device"`
+	State       string `json:"state"`
+	Destination string `json:"destination"`
+	Pool        string `json:"pool"`
+	Members     string `json:"members"`
+}
+
+// Write the inventory as a JSON list of rows with the same columns, which
+// can be read by an import source of the Icinga Director, e.g. using the
+// fileshipper module.
+func (i *Inventory) Director(w io.Writer) error {
+	rows := make([]directorRow, 0)
+	for _, d := range i.Devices {
+		rows = append(rows, directorRow{Type: "device", Name: d.Name, ObjectName: ObjectName(d.Name), Device: d.Name})
+	}
+	for _, p := range i.Pools {
+		rows = append(rows, directorRow{Type: "pool", Name: p.Name, ObjectName: ObjectName(p.Name), Device: i.Device,
+			State: p.AvailabilityState, Members: strings.Join(p.Members, ",")})
+	}
+	for _, v := range i.VirtualServers {
+		rows = append(rows, directorRow{Type: "virtual_server", Name: v.Name, ObjectName: ObjectName(v.Name), Device: i.Device,
+			State: v.AvailabilityState, Destination: v.Destination, Pool: v.Pool})
+	}
+	for _, n := range i.Interfaces {
+		rows = append(rows, directorRow{Type: "interface", Name: n.Name, ObjectName: ObjectName(n.Name), Device: i.Device,
+			State: n.Status})
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(rows)
+}
+
+// Characters not allowed in object names
+var objectNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Convert the name of an F5 object like /Common/web-pool into a name usable
+// for Icinga2 objects like Common_web-pool
+func ObjectName(Name string) string {
+	return strings.Trim(objectNameChars.ReplaceAllString(Name, "_"), "_")
+}
+
+// Quote a string for the Icinga2 configuration language
+func IcingaString(Value string) string {
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t", "$", "$$")
+	return "\"" + r.Replace(Value) + "\""
+}
@@ -2,6 +2,8 @@
 package elasticsearch
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"time"
 
@@ -11,7 +13,9 @@ import (
 
 // Handle the connection to Elasticsearch
 type Elasticsearch struct {
-	Connection *lra.Connection
+	Connection  *lra.Connection
+	deviceField string
+	device      string
 }
 
 // The generic Error response can be used when the actual data is irrelevant or
@@ -86,3 +90,27 @@ func NewElasticsearch(SSL bool, Host string, Port int, User string, Password str
 	e.Connection = c
 	return e, nil
 }
+
+// Restrict all queries built with Filter to the documents of the Device, as
+// identified by the DeviceField. An empty Device disables the filter.
+func (e *Elasticsearch) SetDevice(DeviceField string, Device string) {
+	e.deviceField = DeviceField
+	e.device = Device
+}
+
+// The device set with SetDevice, empty if the queries are not filtered
+func (e *Elasticsearch) Device() string {
+	return e.device
+}
+
+// Wrap the Query clause in a bool query adding a term filter on the device
+// set with SetDevice, so documents of different devices sharing an index are
+// not mixed. Without a device, the Query is returned unchanged.
+func (e *Elasticsearch) Filter(Query string) string {
+	if e.device == "" {
+		return Query
+	}
+	field, _ := json.Marshal(e.deviceField)
+	device, _ := json.Marshal(e.device)
+	return fmt.Sprintf("{\"bool\":{\"filter\":[{\"term\":{%s:%s}},%v]}}", field, device, Query)
+}
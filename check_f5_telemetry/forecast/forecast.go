@@ -0,0 +1,247 @@
+// package forecast fits a linear trend to the history of metrics and projects
+// when they will reach a limit
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Number of buckets the history window is divided into
+const buckets = 100
+
+// A metric to forecast. The values of Field are multiplied with Scale, Unit
+// is used for the text output and PerfUnit for the performance data.
+type Metric struct {
+	Field    string
+	Scale    float64
+	Unit     string
+	PerfUnit string
+}
+
+// A single value of the history
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// A linear trend, Slope is the change per second since Origin
+type Fit struct {
+	Slope     float64
+	Intercept float64
+	Origin    time.Time
+	Points    int
+}
+
+// The Forecast object created and initialized by NewForecast consolidates the
+// connection to Elasticsearch, the nagios object, the index name, the
+// history window and the warning and critical horizons.
+type Forecast struct {
+	index      string
+	window     time.Duration
+	warn       time.Duration
+	crit       time.Duration
+	connection *elasticsearch.Elasticsearch
+	nagios     *nagiosplugin.Check
+}
+
+// Creates a Forecast object containing the connection object to
+// Elasticsearch, a Nagios object, the Index, the history Window used for the
+// trend and the horizons: if the limit is projected to be reached within
+// Warn or Crit, a warning or critical alert is raised. A zero horizon is
+// ignored.
+func NewForecast(Index string, Window time.Duration, Warn time.Duration, Crit time.Duration, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Forecast, error) {
+	var f *Forecast
+
+	logger := log.With().Str("func", "NewForecast").Str("package", "forecast").Logger()
+	logger.Trace().Msg("Enter func")
+	if Window <= 0 {
+		logger.Error().Str("id", "ERR11001001").Str("window", Window.String()).Msg("Invalid history window")
+		return nil, errors.New("The forecast window must be positive")
+	}
+	f = new(Forecast)
+	f.index = Index
+	f.window = Window
+	f.warn = Warn
+	f.crit = Crit
+	f.connection = Connection
+	f.nagios = Nagios
+	return f, nil
+}
+
+// The names of the metrics, sorted alphabetically
+func names(Metrics map[string]Metric) []string {
+	result := make([]string, 0, len(Metrics))
+	for name := range Metrics {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Query the history of the Metrics, averaged per bucket
+func (f *Forecast) Execute(Metrics map[string]Metric) (map[string][]Point, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "forecast").Logger()
+	logger.Trace().Msg("Enter func")
+
+	interval := int64(math.Max(60, f.window.Seconds()/buckets))
+	aggs := make([]string, 0, len(Metrics))
+	for _, name := range names(Metrics) {
+		aggs = append(aggs, fmt.Sprintf("\"%v\":{\"avg\":{\"field\":\"%v\"}}", name, Metrics[name].Field))
+	}
+	query := fmt.Sprintf("{\"size\":0,\"query\":%v,\"aggs\":{\"history\":{\"date_histogram\":{\"field\":\"@timestamp\",\"fixed_interval\":\"%ds\",\"min_doc_count\":1},\"aggs\":{%v}}}}",
+		f.connection.Filter(trend.RangeQuery(f.window)), interval, strings.Join(aggs, ","))
+	data, err := f.connection.Search(f.index, query)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR11002001").
+			Str("query", query).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		f.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, f.index, query))
+		return nil, err
+	}
+
+	points := make(map[string][]Point)
+	list, _ := data.Aggregations["history"]["buckets"].([]interface{})
+	for _, b := range list {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		key, _ := bucket["key"].(float64)
+		ts := time.UnixMilli(int64(key))
+		for name, m := range Metrics {
+			agg, _ := bucket[name].(map[string]interface{})
+			v, ok := agg["value"].(float64)
+			if !ok {
+				continue
+			}
+			points[name] = append(points[name], Point{ts, v * m.Scale})
+		}
+	}
+	for name := range points {
+		sort.Slice(points[name], func(i, j int) bool { return points[name][i].Time.Before(points[name][j].Time) })
+	}
+	return points, nil
+}
+
+// Fit a linear trend to the points using least squares
+func LinearFit(Points []Point) (Fit, error) {
+	fit := Fit{Points: len(Points)}
+	if len(Points) < 2 {
+		return fit, errors.New("at least two points are needed for a trend")
+	}
+	fit.Origin = Points[0].Time
+	var sx, sy, sxx, sxy float64
+	n := float64(len(Points))
+	for _, p := range Points {
+		x := p.Time.Sub(fit.Origin).Seconds()
+		sx += x
+		sy += p.Value
+		sxx += x * x
+		sxy += x * p.Value
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return fit, errors.New("all points have the same timestamp")
+	}
+	fit.Slope = (n*sxy - sx*sy) / d
+	fit.Intercept = (sy - fit.Slope*sx) / n
+	return fit, nil
+}
+
+// The value of the trend at the given time
+func (fit Fit) At(t time.Time) float64 {
+	return fit.Intercept + fit.Slope*t.Sub(fit.Origin).Seconds()
+}
+
+// The projected time from Now until the trend reaches Limit. ok is false if
+// the trend doesn't grow, so the limit is never reached.
+func (fit Fit) TimeToLimit(Limit float64, Now time.Time) (time.Duration, bool) {
+	current := fit.At(Now)
+	if current >= Limit {
+		return 0, true
+	}
+	if fit.Slope <= 0 {
+		return 0, false
+	}
+	seconds := (Limit - current) / fit.Slope
+	if seconds > float64(math.MaxInt64)/float64(time.Second) {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// Human readable duration in days and hours, e.g. "12d 3h"
+func FormatDuration(d time.Duration) string {
+	days := int64(d / (24 * time.Hour))
+	hours := int64((d % (24 * time.Hour)) / time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+	minutes := int64((d % time.Hour) / time.Minute)
+	return fmt.Sprintf("%dh %dm", hours, minutes)
+}
+
+// Fit the trend of every metric and check when it reaches Limit
+func (f *Forecast) Check(Metrics map[string]Metric, Points map[string][]Point, Limit float64) {
+	logger := log.With().Str("func", "Check").Str("package", "forecast").Logger()
+	logger.Trace().Msg("Enter func")
+
+	now := time.Now()
+	for _, name := range names(Metrics) {
+		m := Metrics[name]
+		fit, err := LinearFit(Points[name])
+		if err != nil {
+			logger.Error().Str("id", "ERR11003001").Str("metric", name).Err(err).Msg("Could not fit a trend")
+			f.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: not enough history of %v in the last %v for a forecast", name, trend.FormatWindow(f.window)))
+			continue
+		}
+		ttl, reached := fit.TimeToLimit(Limit, now)
+		growth := units.Format(fit.Slope*24*3600, m.Unit) + " per day"
+		logger.Debug().Str("id", "DBG11003001").
+			Str("metric", name).
+			Int("points", fit.Points).
+			Float64("slope", fit.Slope).
+			Float64("current", fit.At(now)).
+			Bool("reached", reached).
+			Str("ttl", ttl.String()).
+			Msg("Trend calculated")
+
+		limit := units.Format(Limit, m.Unit)
+		switch {
+		case !reached:
+			f.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v is not growing towards %v (%v)", name, limit, growth))
+		case ttl == 0:
+			f.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v trend has reached the limit %v", name, limit))
+		case f.crit > 0 && ttl <= f.crit:
+			f.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v is projected to reach %v in %v (%v)", name, limit, FormatDuration(ttl), growth))
+		case f.warn > 0 && ttl <= f.warn:
+			f.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v is projected to reach %v in %v (%v)", name, limit, FormatDuration(ttl), growth))
+		default:
+			f.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v is projected to reach %v in %v (%v)", name, limit, FormatDuration(ttl), growth))
+		}
+
+		if reached {
+			p, _ := nagiosplugin.NewFloatPerfDatumValue(math.Round(ttl.Seconds()))
+			f.nagios.AddPerfDatum(name+"_time_to_limit", "s", p, nil, nil, nil, nil)
+		}
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(fit.Slope * 24 * 3600)
+		f.nagios.AddPerfDatum(name+"_growth_per_day", m.PerfUnit, p, nil, nil, nil, nil)
+	}
+}
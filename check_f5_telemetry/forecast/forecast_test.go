@@ -0,0 +1,74 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// Points at hourly intervals starting at Origin
+func hourly(Origin time.Time, Values ...float64) []Point {
+	points := make([]Point, 0, len(Values))
+	for i, v := range Values {
+		points = append(points, Point{Origin.Add(time.Duration(i) * time.Hour), v})
+	}
+	return points
+}
+
+func TestLinearFit(t *testing.T) {
+	origin := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		points        []Point
+		wantSlope     float64
+		wantIntercept float64
+		wantErr       bool
+	}{
+		{"rising", hourly(origin, 10, 20, 30), 10.0 / 3600, 10, false},
+		{"falling", hourly(origin, 30, 20, 10), -10.0 / 3600, 30, false},
+		{"constant values", hourly(origin, 50, 50, 50, 50), 0, 50, false},
+		{"noisy", hourly(origin, 0, 2, 2, 4), 1.2 / 3600, 0.2, false},
+		{"single point", hourly(origin, 10), 0, 0, true},
+		{"no points", nil, 0, 0, true},
+		{"same timestamp", []Point{{origin, 10}, {origin, 20}}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, err := LinearFit(tt.points)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LinearFit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if math.Abs(fit.Slope-tt.wantSlope) > 1e-12 || math.Abs(fit.Intercept-tt.wantIntercept) > 1e-9 {
+				t.Errorf("LinearFit() = slope %v intercept %v, want %v %v", fit.Slope, fit.Intercept, tt.wantSlope, tt.wantIntercept)
+			}
+		})
+	}
+}
+
+func TestTimeToLimit(t *testing.T) {
+	origin := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		fit    Fit
+		limit  float64
+		now    time.Time
+		want   time.Duration
+		wantOk bool
+	}{
+		{"reached in 5h", Fit{Origin: origin, Slope: 10.0 / 3600, Intercept: 50}, 100, origin, 5 * time.Hour, true},
+		{"already reached", Fit{Origin: origin, Slope: 10.0 / 3600, Intercept: 50}, 40, origin, 0, true},
+		{"falling", Fit{Origin: origin, Slope: -1, Intercept: 50}, 100, origin, 0, false},
+		{"constant", Fit{Origin: origin, Slope: 0, Intercept: 50}, 100, origin, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.fit.TimeToLimit(tt.limit, tt.now)
+			if ok != tt.wantOk || (ok && math.Abs((got-tt.want).Seconds()) > 1) {
+				t.Errorf("TimeToLimit(%v) = %v, %v, want %v, %v", tt.limit, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package gtm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/pool"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The kinds of GTM objects which can be checked
+const (
+	KindWideIp = "wideip"
+	KindPool   = "pool"
+)
+
+// The Gtm object created and initialized by NewGtm consolidates the
+// connection to Elasticsearch, the nagios object, the index name and the
+// selection of the wide IPs or GSLB pools needed to run the check.
+type Gtm struct {
+	index           string
+	section         string
+	name            *regexp.Regexp
+	ignore_disabled bool
+	connection      *elasticsearch.Elasticsearch
+	nagios          *nagiosplugin.Check
+}
+
+// State of a single wide IP or GSLB pool. Members are only filled for GSLB
+// pools, the counters contain the request and resolution statistics.
+type GtmObject struct {
+	AvailabilityState string
+	EnabledState      string
+	Members           pool.PoolState
+	Counters          map[string]float64
+}
+
+// Consolidated states of the selected objects
+type GtmState struct {
+	Timestamp time.Time
+	Objects   map[string]GtmObject
+}
+
+// The object names, sorted alphabetically
+func (s *GtmState) Names() []string {
+	names := make([]string, 0, len(s.Objects))
+	for name := range s.Objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates a Gtm object containing the connection object to Elasticsearch, a
+// Nagios object and the Index. Kind is either "wideip" or "pool", RecordType
+// the DNS record type ("a" or "aaaa") and Name a regular expression matching
+// the object names.
+func NewGtm(Index string, Kind string, RecordType string, Name string, IgnoreDisabled bool, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Gtm, error) {
+	var g *Gtm
+	var err error
+
+	logger := log.With().Str("func", "NewGtm").Str("package", "gtm").Logger()
+	logger.Trace().Msg("Enter func")
+	g = new(Gtm)
+	g.index = Index
+	rt := strings.ToLower(RecordType)
+	if rt != "a" && rt != "aaaa" {
+		logger.Error().Str("id", "ERR60010001").Str("record_type", RecordType).Msg("Illegal record type")
+		return nil, errors.New("Illegal record type " + RecordType + ", use a or aaaa")
+	}
+	switch Kind {
+	case KindWideIp:
+		g.section = rt + "WideIps"
+	case KindPool:
+		g.section = rt + "Pools"
+	default:
+		logger.Error().Str("id", "ERR60010002").Str("kind", Kind).Msg("Illegal GTM object kind")
+		return nil, errors.New("Illegal GTM object kind " + Kind + ", use " + KindWideIp + " or " + KindPool)
+	}
+	if g.name, err = regexp.Compile(Name); err != nil {
+		logger.Error().Str("id", "ERR60010003").
+			Str("regex", Name).
+			Err(err).
+			Msg("Could not compile name pattern")
+		return nil, err
+	}
+	g.ignore_disabled = IgnoreDisabled
+	g.connection = Connection
+	g.nagios = Nagios
+	return g, nil
+}
+
+// Execute the query
+func (g *Gtm) Execute() (*GtmState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "gtm").Logger()
+	logger.Trace().Msg("Enter func")
+	q := strings.ReplaceAll("{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + g.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"_SECTION_.*\"],\"_source\":false}", "_SECTION_", g.section)
+	data, err := g.connection.Search(g.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR60020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		g.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, g.index, q))
+		return nil, err
+	}
+	return g.gatherGtmState(data)
+}
+
+// Convert the latest document with the GTM objects, see Execute
+func (g *Gtm) Gather(e *elasticsearch.ElasticsearchResult) (*GtmState, error) {
+	return g.gatherGtmState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (g *Gtm) gatherGtmState(e *elasticsearch.ElasticsearchResult) (*GtmState, error) {
+	var fields elasticsearch.HitElement
+	logger := log.With().Str("func", "gatherGtmState").Str("package", "gtm").Str("section", g.section).Logger()
+	logger.Trace().Msg("Enter func")
+	if len(e.Hits.Hits) == 0 {
+		fields = make(elasticsearch.HitElement)
+	} else {
+		fields = e.Hits.Hits[0].Fields
+	}
+	if len(fields) == 0 {
+		g.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No data for %v. ", g.section))
+		logger.Error().Str("id", "ERR60030001").Msg("No data for GTM check")
+		return nil, errors.New("No data for " + g.section)
+	}
+	s := new(GtmState)
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		g.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR60030002").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, err
+	}
+	s.Timestamp = ts
+	s.Objects = make(map[string]GtmObject)
+
+	prefix := g.section + "."
+	suffix := ".availabilityState.keyword"
+	members := regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + "(.+?)\\.members\\.(.+)" + regexp.QuoteMeta(suffix) + "$")
+	for field := range fields {
+		if !strings.HasPrefix(field, prefix) || !strings.HasSuffix(field, suffix) || members.MatchString(field) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(field, prefix), suffix)
+		if !g.name.MatchString(name) {
+			logger.Trace().Str("id", "DBG60030001").Str("name", name).Msg("Object doesn't match")
+			continue
+		}
+		o := GtmObject{
+			AvailabilityState: stringField(fields, prefix+name+".availabilityState"),
+			EnabledState:      stringField(fields, prefix+name+".enabledState"),
+			Counters:          make(map[string]float64),
+		}
+		for c, v := range fields {
+			counter := strings.TrimPrefix(c, prefix+name+".")
+			if counter == c || strings.Contains(counter, ".") {
+				continue
+			}
+			if l, ok := v.([]interface{}); ok && len(l) > 0 {
+				if n, ok := l[0].(float64); ok {
+					o.Counters[counter] = n
+				}
+			}
+		}
+		logger.Debug().Str("id", "DBG60030002").
+			Str("name", name).
+			Str("availabilityState", o.AvailabilityState).
+			Str("enabledState", o.EnabledState).
+			Msg("Object found")
+		s.Objects[name] = o
+	}
+	for field := range fields {
+		match := members.FindStringSubmatch(field)
+		if match == nil {
+			continue
+		}
+		o, found := s.Objects[match[1]]
+		if !found {
+			continue
+		}
+		mprefix := prefix + match[1] + ".members." + match[2] + "."
+		m := pool.PoolMemberData{
+			AvailabilityState: stringField(fields, mprefix+"availabilityState"),
+			EnabledState:      stringField(fields, mprefix+"enabledState"),
+			StatusReason:      stringField(fields, mprefix+"status.statusReason"),
+		}
+		o.Members.AddMember(match[2], m, g.ignore_disabled)
+		s.Objects[match[1]] = o
+	}
+	if len(s.Objects) == 0 {
+		g.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No %v matching %v", g.section, g.name))
+		logger.Error().Str("id", "ERR60030003").Str("regex", g.name.String()).Msg("No matching objects")
+		return nil, errors.New("No " + g.section + " matching " + g.name.String())
+	}
+	return s, nil
+}
+
+// Get a string field, preferring the keyword variant. Returns an empty string
+// if the field doesn't exist.
+func stringField(fields elasticsearch.HitElement, fieldname string) string {
+	for _, f := range []string{fieldname + ".keyword", fieldname} {
+		if v, ok := fields[f].([]interface{}); ok && len(v) > 0 {
+			return fmt.Sprintf("%v", v[0])
+		}
+	}
+	return ""
+}
+
+// Check the availability of the selected objects. Objects which are enabled
+// but not available raise a critical alert. For GSLB pools, Warn and Crit
+// are ranges for the number of unavailable members.
+func (g *Gtm) Check(s *GtmState, Warn string, Crit string, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "gtm").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	for _, name := range s.Names() {
+		o := s.Objects[name]
+		if o.EnabledState != "" && o.EnabledState != "enabled" {
+			g.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("%v is %v", name, o.EnabledState))
+		} else if o.AvailabilityState != "available" {
+			g.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v is %v", name, o.AvailabilityState))
+			ok = false
+		}
+		if o.Members.TotalMembers == 0 {
+			continue
+		}
+		unavailable := float64(o.Members.UnavailableMembers)
+		if threshold.CheckRange(g.nagios, Crit, unavailable, "critical") {
+			g.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v of %v members of %v unavailable", o.Members.UnavailableMembers, o.Members.TotalMembers, name))
+			ok = false
+		} else if threshold.CheckRange(g.nagios, Warn, unavailable, "warning") {
+			g.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v of %v members of %v unavailable", o.Members.UnavailableMembers, o.Members.TotalMembers, name))
+			ok = false
+		}
+		for _, member := range o.Members.Members.Names() {
+			m := o.Members.Members[member]
+			if m.AvailabilityState != "available" {
+				msg := fmt.Sprintf("Member %v of %v: %v, %v", member, name, m.EnabledState, m.AvailabilityState)
+				if m.StatusReason != "" {
+					msg += ": " + m.StatusReason
+				}
+				g.nagios.AddResult(nagiosplugin.OK, msg)
+			}
+		}
+	}
+	if ok {
+		g.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v %v available", len(s.Objects), g.section))
+	}
+	g.checkAddPerfdata(s)
+}
+
+// add the request and resolution counters and the member counts to the
+// performance data
+func (g *Gtm) checkAddPerfdata(s *GtmState) {
+	for _, name := range s.Names() {
+		o := s.Objects[name]
+		counters := make([]string, 0, len(o.Counters))
+		for c := range o.Counters {
+			counters = append(counters, c)
+		}
+		sort.Strings(counters)
+		for _, c := range counters {
+			p, _ := nagiosplugin.NewFloatPerfDatumValue(o.Counters[c])
+			g.nagios.AddPerfDatum(name+"_"+c, "c", p, nil, nil, nil, nil)
+		}
+		if o.Members.TotalMembers > 0 {
+			p, _ := nagiosplugin.NewFloatPerfDatumValue(float64(o.Members.UnavailableMembers))
+			g.nagios.AddPerfDatum(name+"_unavailable_member_count", "", p, nil, nil, nil, nil)
+			p, _ = nagiosplugin.NewFloatPerfDatumValue(float64(o.Members.TotalMembers))
+			g.nagios.AddPerfDatum(name+"_total_members", "", p, nil, nil, nil, nil)
+		}
+	}
+}
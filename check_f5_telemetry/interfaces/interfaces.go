@@ -0,0 +1,343 @@
+package interfaces
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The metrics which can have thresholds, all of them are rates per second
+// between the two latest samples
+var MetricFields = [...]string{
+	"errors",
+	"drops",
+	"bitsIn",
+	"bitsOut"}
+
+// The Interfaces object created and initialized by NewInterfaces consolidates
+// the connection to Elasticsearch, the nagios object, the index name and the
+// interface filter needed to run the check.
+type Interfaces struct {
+	index      string
+	include    *regexp.Regexp
+	exclude    *regexp.Regexp
+	connection *elasticsearch.Elasticsearch
+	nagios     *nagiosplugin.Check
+}
+
+// Data of a single interface. The counters are taken from the latest sample,
+// the rates are calculated from the difference to the previous sample if
+// HasRates is true.
+type InterfaceData struct {
+	Status   string
+	BitsIn   float64
+	BitsOut  float64
+	Errors   float64
+	Drops    float64
+	HasRates bool
+	Rates    map[string]float64
+}
+
+// States of the various interfaces
+type InterfaceList map[string]InterfaceData
+
+// The interface names, sorted alphabetically
+func (l InterfaceList) Names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Consolidated state of all selected interfaces
+type InterfaceState struct {
+	Timestamp         time.Time
+	PreviousTimestamp time.Time
+	Interfaces        InterfaceList
+}
+
+// Creates an Interfaces object containing the connection object to
+// Elasticsearch, a Nagios object, the Index and the regular expressions
+// selecting the interfaces. Empty expressions disable the filter.
+func NewInterfaces(Index string, Include string, Exclude string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Interfaces, error) {
+	var i *Interfaces
+	var err error
+
+	logger := log.With().Str("func", "NewInterfaces").Str("package", "interfaces").Logger()
+	logger.Trace().Msg("Enter func")
+	i = new(Interfaces)
+	i.index = Index
+	if Include != "" {
+		if i.include, err = regexp.Compile(Include); err != nil {
+			logger.Error().Str("id", "ERR50010001").
+				Str("regex", Include).
+				Err(err).
+				Msg("Could not compile interface include pattern")
+			return nil, err
+		}
+	}
+	if Exclude != "" {
+		if i.exclude, err = regexp.Compile(Exclude); err != nil {
+			logger.Error().Str("id", "ERR50010002").
+				Str("regex", Exclude).
+				Err(err).
+				Msg("Could not compile interface exclude pattern")
+			return nil, err
+		}
+	}
+	i.connection = Connection
+	i.nagios = Nagios
+	return i, nil
+}
+
+// Check, whether an interface passes the include and exclude patterns
+func (i *Interfaces) selected(name string) bool {
+	if i.include != nil && !i.include.MatchString(name) {
+		return false
+	}
+	if i.exclude != nil && i.exclude.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// Execute the query, fetching the two latest samples to calculate the rates
+func (i *Interfaces) Execute() (*InterfaceState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "interfaces").Logger()
+	logger.Trace().Msg("Enter func")
+	q := "{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + i.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"networkInterfaces.*\"],\"_source\":false}"
+	data, err := i.connection.Search(i.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR50020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		i.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, i.index, q))
+		return nil, err
+	}
+	return i.gatherInterfaceState(data)
+}
+
+// Convert the two latest documents with the interface counters, see Execute
+func (i *Interfaces) Gather(e *elasticsearch.ElasticsearchResult) (*InterfaceState, error) {
+	return i.gatherInterfaceState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (i *Interfaces) gatherInterfaceState(e *elasticsearch.ElasticsearchResult) (*InterfaceState, error) {
+	logger := log.With().Str("func", "gatherInterfaceState").Str("package", "interfaces").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) == 0 || len(e.Hits.Hits[0].Fields) == 0 {
+		i.nagios.AddResult(nagiosplugin.UNKNOWN, "No data for interfaces check")
+		logger.Error().Str("id", "ERR50030001").Msg("No data for interfaces check")
+		return nil, errors.New("No data for interfaces check")
+	}
+	s := new(InterfaceState)
+	current, ts, err := i.parseSample(e.Hits.Hits[0].Fields)
+	if err != nil {
+		return nil, err
+	}
+	s.Timestamp = ts
+	s.Interfaces = current
+	if len(current) == 0 {
+		i.nagios.AddResult(nagiosplugin.UNKNOWN, "No matching interfaces in telemetry data")
+		logger.Error().Str("id", "ERR50030002").Msg("No matching interfaces in telemetry data")
+		return nil, errors.New("No matching interfaces in telemetry data")
+	}
+	if len(e.Hits.Hits) < 2 {
+		logger.Warn().Str("id", "WRN50030001").Msg("Only one sample, can't calculate rates")
+		return s, nil
+	}
+	previous, pts, err := i.parseSample(e.Hits.Hits[1].Fields)
+	if err != nil {
+		return nil, err
+	}
+	s.PreviousTimestamp = pts
+	seconds := ts.Sub(pts).Seconds()
+	if seconds <= 0 {
+		logger.Warn().Str("id", "WRN50030002").Msg("Samples have the same timestamp, can't calculate rates")
+		return s, nil
+	}
+	for name, c := range current {
+		p, found := previous[name]
+		if !found {
+			continue
+		}
+		if c.BitsIn < p.BitsIn || c.BitsOut < p.BitsOut || c.Errors < p.Errors || c.Drops < p.Drops {
+			logger.Info().Str("id", "INF50030001").Str("interface", name).Msg("Counters have been reset")
+			continue
+		}
+		c.HasRates = true
+		c.Rates = map[string]float64{
+			"errors":  (c.Errors - p.Errors) / seconds,
+			"drops":   (c.Drops - p.Drops) / seconds,
+			"bitsIn":  (c.BitsIn - p.BitsIn) / seconds,
+			"bitsOut": (c.BitsOut - p.BitsOut) / seconds,
+		}
+		current[name] = c
+	}
+	return s, nil
+}
+
+// Parse the interfaces from a single sample
+func (i *Interfaces) parseSample(fields elasticsearch.HitElement) (InterfaceList, time.Time, error) {
+	logger := log.With().Str("func", "parseSample").Str("package", "interfaces").Logger()
+	logger.Trace().Msg("Enter func")
+
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		i.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR50040001").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, ts, err
+	}
+
+	list := make(InterfaceList)
+	prefix := "networkInterfaces."
+	suffix := ".status.keyword"
+	for field := range fields {
+		if !strings.HasPrefix(field, prefix) || !strings.HasSuffix(field, suffix) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(field, prefix), suffix)
+		if !i.selected(name) {
+			logger.Debug().Str("id", "DBG50040001").Str("interface", name).Msg("Interface filtered out")
+			continue
+		}
+		var d InterfaceData
+		d.Status = fmt.Sprintf("%v", fields[field].([]interface{})[0])
+		d.BitsIn = floatField(fields, prefix+name+".counters.bitsIn")
+		d.BitsOut = floatField(fields, prefix+name+".counters.bitsOut")
+		d.Errors = floatField(fields, prefix+name+".counters.errorsAll")
+		d.Drops = floatField(fields, prefix+name+".counters.dropsAll")
+		list[name] = d
+	}
+	return list, ts, nil
+}
+
+// Get a numeric field, missing fields are returned as 0
+func floatField(fields elasticsearch.HitElement, fieldname string) float64 {
+	if v, ok := fields[fieldname].([]interface{}); ok && len(v) > 0 {
+		if f, ok := v[0].(float64); ok {
+			return f
+		}
+	}
+	return 0
+}
+
+// Find the metric in MetricFields, ignoring the case as viper converts all
+// keys in the configuration file to lower case
+func MetricName(Name string) (string, bool) {
+	for _, f := range MetricFields {
+		if strings.EqualFold(f, Name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to the error rate, unless it has its own
+// threshold.
+func MergeThresholds(Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string) (threshold.Thresholds, error) {
+	return threshold.Merge(Warn, Crit, []string{"errors"}, Config, Definitions, MetricName)
+}
+
+// Check the interfaces. Interfaces which are down raise a critical alert,
+// interfaces which are disabled or not in use (uninit, miss) are not expected
+// to be up. The rates are checked
+// against the thresholds.
+func (i *Interfaces) Check(s *InterfaceState, Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "interfaces").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	up := 0
+	for _, name := range s.Interfaces.Names() {
+		d := s.Interfaces[name]
+		switch d.Status {
+		case "up":
+			up++
+		case "down":
+			i.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: Interface %v is down", name))
+			ok = false
+		default:
+			i.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("Interface %v is %v", name, d.Status))
+		}
+		if !d.HasRates {
+			continue
+		}
+		for _, f := range MetricFields {
+			th, found := Thresholds[f]
+			if !found {
+				continue
+			}
+			v := d.Rates[f]
+			logger.Debug().Str("id", "DBG50050001").
+				Str("interface", name).
+				Str("field", f).
+				Float64("rate", v).
+				Msg("Check threshold")
+			if threshold.CheckRange(i.nagios, th.Critical, v, "critical") {
+				i.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: Interface %v %v rate %v is outside the critical range %v", name, f, units.Format(v, "/s"), th.Critical))
+				ok = false
+			} else if threshold.CheckRange(i.nagios, th.Warning, v, "warning") {
+				i.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: Interface %v %v rate %v is outside the warning range %v", name, f, units.Format(v, "/s"), th.Warning))
+				ok = false
+			}
+		}
+	}
+	if ok {
+		i.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v of %v interfaces up", up, len(s.Interfaces)))
+	}
+	if s.PreviousTimestamp.IsZero() {
+		i.nagios.AddResult(nagiosplugin.OK, "Only one sample available, rates can't be calculated")
+	}
+	i.checkAddPerfdata(s, Thresholds)
+}
+
+// add the counters and rates of every interface to the performance data
+func (i *Interfaces) checkAddPerfdata(s *InterfaceState, Thresholds threshold.Thresholds) {
+	for _, name := range s.Interfaces.Names() {
+		d := s.Interfaces[name]
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(d.BitsIn)
+		i.nagios.AddPerfDatum(name+"_bits_in", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.BitsOut)
+		i.nagios.AddPerfDatum(name+"_bits_out", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.Errors)
+		i.nagios.AddPerfDatum(name+"_errors", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.Drops)
+		i.nagios.AddPerfDatum(name+"_drops", "c", p, nil, nil, nil, nil)
+		if !d.HasRates {
+			continue
+		}
+		for _, f := range []string{"errors", "drops"} {
+			th := Thresholds[f]
+			p, _ = nagiosplugin.NewFloatPerfDatumValue(d.Rates[f])
+			i.nagios.AddPerfDatum(name+"_"+f+"_rate", "", p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+		}
+	}
+}
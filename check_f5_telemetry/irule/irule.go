@@ -0,0 +1,307 @@
+package irule
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The metrics which can have thresholds. Failures and aborts are the new ones
+// since the previous sample, avgCycles is the highest average of all events
+// of the rule.
+var MetricFields = [...]string{
+	"failures",
+	"aborts",
+	"avgCycles"}
+
+// The event counters read from the telemetry data
+var eventFields = regexp.MustCompile("^iRules\\.(.+)\\.events\\.([^.]+)\\.(totalExecutions|failures|aborts|avgCycles)$")
+
+// The IRule object created and initialized by NewIRule consolidates the
+// connection to Elasticsearch, the nagios object, the index name and the rule
+// selection needed to run the check.
+type IRule struct {
+	index      string
+	include    *regexp.Regexp
+	connection *elasticsearch.Elasticsearch
+	nagios     *nagiosplugin.Check
+}
+
+// Statistics of a single iRule, summed up over all events. The new failures
+// and aborts are the difference to the previous sample, HasPrevious is false
+// if there was none or the counters have been reset.
+type IRuleData struct {
+	TotalExecutions float64
+	Failures        float64
+	Aborts          float64
+	AvgCycles       float64
+	NewFailures     float64
+	NewAborts       float64
+	HasPrevious     bool
+}
+
+// Statistics of the selected iRules
+type IRuleList map[string]IRuleData
+
+// The rule names, sorted alphabetically
+func (l IRuleList) Names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Consolidated state of the iRules
+type IRuleState struct {
+	Timestamp         time.Time
+	PreviousTimestamp time.Time
+	Rules             IRuleList
+}
+
+// Creates an IRule object containing the connection object to Elasticsearch,
+// a Nagios object, the Index and a regular expression selecting the rules
+func NewIRule(Index string, Include string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*IRule, error) {
+	var r *IRule
+	var err error
+
+	logger := log.With().Str("func", "NewIRule").Str("package", "irule").Logger()
+	logger.Trace().Msg("Enter func")
+	r = new(IRule)
+	r.index = Index
+	if r.include, err = regexp.Compile(Include); err != nil {
+		logger.Error().Str("id", "ERR80010001").
+			Str("regex", Include).
+			Err(err).
+			Msg("Could not compile rule pattern")
+		return nil, err
+	}
+	r.connection = Connection
+	r.nagios = Nagios
+	return r, nil
+}
+
+// Execute the query, fetching the two latest samples
+func (r *IRule) Execute() (*IRuleState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "irule").Logger()
+	logger.Trace().Msg("Enter func")
+	q := "{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + r.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"iRules.*\"],\"_source\":false}"
+	data, err := r.connection.Search(r.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR80020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		r.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, r.index, q))
+		return nil, err
+	}
+	return r.gatherIRuleState(data)
+}
+
+// Convert the two latest documents with the iRule counters, see Execute
+func (r *IRule) Gather(e *elasticsearch.ElasticsearchResult) (*IRuleState, error) {
+	return r.gatherIRuleState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (r *IRule) gatherIRuleState(e *elasticsearch.ElasticsearchResult) (*IRuleState, error) {
+	logger := log.With().Str("func", "gatherIRuleState").Str("package", "irule").Logger()
+	logger.Trace().Msg("Enter func")
+
+	s := new(IRuleState)
+	var previous IRuleList
+	for i, hit := range e.Hits.Hits {
+		rules, ts, err := r.parseSample(hit.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			s.Timestamp = ts
+			s.Rules = rules
+		} else {
+			s.PreviousTimestamp = ts
+			previous = rules
+		}
+	}
+	if len(s.Rules) == 0 {
+		r.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No iRules matching %v", r.include))
+		logger.Error().Str("id", "ERR80030001").Str("regex", r.include.String()).Msg("No matching iRules")
+		return nil, errors.New("No iRules matching " + r.include.String())
+	}
+	for name, d := range s.Rules {
+		p, found := previous[name]
+		if !found || d.TotalExecutions < p.TotalExecutions || d.Failures < p.Failures || d.Aborts < p.Aborts {
+			continue
+		}
+		d.NewFailures = d.Failures - p.Failures
+		d.NewAborts = d.Aborts - p.Aborts
+		d.HasPrevious = true
+		s.Rules[name] = d
+	}
+	return s, nil
+}
+
+// Parse the selected rules from a single sample
+func (r *IRule) parseSample(fields elasticsearch.HitElement) (IRuleList, time.Time, error) {
+	logger := log.With().Str("func", "parseSample").Str("package", "irule").Logger()
+	logger.Trace().Msg("Enter func")
+
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		r.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR80040001").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, ts, err
+	}
+	rules := make(IRuleList)
+	for field, v := range fields {
+		match := eventFields.FindStringSubmatch(field)
+		if match == nil || !r.include.MatchString(match[1]) {
+			continue
+		}
+		l, ok := v.([]interface{})
+		if !ok || len(l) == 0 {
+			continue
+		}
+		n, ok := l[0].(float64)
+		if !ok {
+			continue
+		}
+		d := rules[match[1]]
+		switch match[3] {
+		case "totalExecutions":
+			d.TotalExecutions += n
+		case "failures":
+			d.Failures += n
+		case "aborts":
+			d.Aborts += n
+		case "avgCycles":
+			if n > d.AvgCycles {
+				d.AvgCycles = n
+			}
+		}
+		logger.Trace().Str("id", "DBG80040001").
+			Str("rule", match[1]).
+			Str("event", match[2]).
+			Str("counter", match[3]).
+			Float64("value", n).
+			Msg("Counter found")
+		rules[match[1]] = d
+	}
+	return rules, ts, nil
+}
+
+// Find the metric in MetricFields, ignoring the case as viper converts all
+// keys in the configuration file to lower case
+func MetricName(Name string) (string, bool) {
+	for _, f := range MetricFields {
+		if strings.EqualFold(f, Name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to avgCycles. Unless they have their own
+// thresholds, any failures or aborts raise a warning.
+func MergeThresholds(Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string) (threshold.Thresholds, error) {
+	thresholds, err := threshold.Merge(Warn, Crit, []string{"avgCycles"}, Config, Definitions, MetricName)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []string{"failures", "aborts"} {
+		if _, found := thresholds[f]; !found {
+			thresholds[f] = threshold.Threshold{Warning: "0"}
+		}
+	}
+	return thresholds, nil
+}
+
+// The value of a metric for the threshold check. Without a previous sample,
+// the absolute failures and aborts are used.
+func (d IRuleData) value(Metric string) float64 {
+	switch Metric {
+	case "failures":
+		if d.HasPrevious {
+			return d.NewFailures
+		}
+		return d.Failures
+	case "aborts":
+		if d.HasPrevious {
+			return d.NewAborts
+		}
+		return d.Aborts
+	}
+	return d.AvgCycles
+}
+
+// Check the failures, aborts and average cycles of every selected rule
+func (r *IRule) Check(s *IRuleState, Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "irule").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	for _, name := range s.Rules.Names() {
+		d := s.Rules[name]
+		for _, f := range MetricFields {
+			th, found := Thresholds[f]
+			if !found {
+				continue
+			}
+			v := d.value(f)
+			logger.Debug().Str("id", "DBG80050001").
+				Str("rule", name).
+				Str("field", f).
+				Float64("value", v).
+				Msg("Check threshold")
+			if threshold.CheckRange(r.nagios, th.Critical, v, "critical") {
+				r.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: iRule %v %v %v is outside the critical range %v", name, f, v, th.Critical))
+				ok = false
+			} else if threshold.CheckRange(r.nagios, th.Warning, v, "warning") {
+				r.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: iRule %v %v %v is outside the warning range %v", name, f, v, th.Warning))
+				ok = false
+			}
+		}
+	}
+	if ok {
+		r.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v iRules without failures or aborts", len(s.Rules)))
+	}
+	r.checkAddPerfdata(s, Thresholds)
+}
+
+// add the counters of every rule to the performance data
+func (r *IRule) checkAddPerfdata(s *IRuleState, Thresholds threshold.Thresholds) {
+	th := Thresholds["avgCycles"]
+	for _, name := range s.Rules.Names() {
+		d := s.Rules[name]
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(d.TotalExecutions)
+		r.nagios.AddPerfDatum(name+"_executions", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.Failures)
+		r.nagios.AddPerfDatum(name+"_failures", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.Aborts)
+		r.nagios.AddPerfDatum(name+"_aborts", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(d.AvgCycles)
+		r.nagios.AddPerfDatum(name+"_avg_cycles", "", p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+	}
+}
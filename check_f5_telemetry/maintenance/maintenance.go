@@ -0,0 +1,313 @@
+// package maintenance evaluates the maintenance windows from the
+// configuration file. Problems of objects in maintenance are reported as OK.
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/rs/zerolog/log"
+)
+
+// The longest duration of a scheduled maintenance window
+const maxDuration = 31 * 24 * time.Hour
+
+// A maintenance window as defined in the configuration file. It either has
+// an explicit Start and End or a cron-like Schedule ("minute hour
+// day-of-month month day-of-week") and a Duration. Pools, Members and Devices
+// are regular expressions limiting the scope, at least one of them must be
+// given.
+type Window struct {
+	Name     string   `mapstructure:"name"`
+	Start    string   `mapstructure:"start"`
+	End      string   `mapstructure:"end"`
+	Schedule string   `mapstructure:"schedule"`
+	Duration string   `mapstructure:"duration"`
+	Pools    []string `mapstructure:"pools"`
+	Members  []string `mapstructure:"members"`
+	Devices  []string `mapstructure:"devices"`
+}
+
+// The object a check evaluates. Empty fields are not part of the scope, so a
+// window limited to members doesn't match a check without a member.
+type Scope struct {
+	Device string
+	Pool   string
+	Member string
+}
+
+// A compiled maintenance window
+type window struct {
+	name     string
+	start    time.Time
+	end      time.Time
+	cron     *cron
+	duration time.Duration
+	pools    []*regexp.Regexp
+	members  []*regexp.Regexp
+	devices  []*regexp.Regexp
+}
+
+// The compiled maintenance windows created by NewSchedule. A nil Schedule
+// has no windows.
+type Schedule struct {
+	windows []window
+}
+
+// Compile the maintenance windows from the configuration
+func NewSchedule(Windows []Window) (*Schedule, error) {
+	var err error
+
+	logger := log.With().Str("func", "NewSchedule").Str("package", "maintenance").Logger()
+	logger.Trace().Msg("Enter func")
+	s := new(Schedule)
+	for i, w := range Windows {
+		name := w.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		c := window{name: name}
+		switch {
+		case w.Schedule != "":
+			if c.cron, err = parseCron(w.Schedule); err != nil {
+				break
+			}
+			if c.duration, err = units.ParseDuration(w.Duration); err == nil && (c.duration <= 0 || c.duration > maxDuration) {
+				err = errors.New("duration must be between 1m and 31d")
+			}
+		case w.Start != "" && w.End != "":
+			if c.start, err = parseTime(w.Start); err != nil {
+				break
+			}
+			if c.end, err = parseTime(w.End); err == nil && !c.end.After(c.start) {
+				err = errors.New("end must be after start")
+			}
+		default:
+			err = errors.New("either schedule and duration or start and end are needed")
+		}
+		if err == nil {
+			if len(w.Pools)+len(w.Members)+len(w.Devices) == 0 {
+				err = errors.New("at least one of pools, members or devices is needed")
+			}
+		}
+		if err == nil {
+			if c.pools, err = compile(w.Pools); err == nil {
+				if c.members, err = compile(w.Members); err == nil {
+					c.devices, err = compile(w.Devices)
+				}
+			}
+		}
+		if err != nil {
+			logger.Error().Str("id", "ERR12001001").Str("window", name).Err(err).Msg("Invalid maintenance window")
+			return nil, fmt.Errorf("Invalid maintenance window %v: %v", name, err)
+		}
+		s.windows = append(s.windows, c)
+	}
+	return s, nil
+}
+
+// Parse an absolute time, either RFC3339 or "2006-01-02 15:04" in local time
+func parseTime(Value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, Value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", Value, time.Local)
+}
+
+// Compile the patterns, they have to match the whole name
+func compile(Patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(Patterns))
+	for _, p := range Patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+// Check whether Value matches one of the patterns. Without patterns, the
+// window is not limited, otherwise an empty Value never matches.
+func matches(Patterns []*regexp.Regexp, Value string) bool {
+	if len(Patterns) == 0 {
+		return true
+	}
+	if Value == "" {
+		return false
+	}
+	for _, re := range Patterns {
+		if re.MatchString(Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// The end of the window if it is active at Now, ok is false otherwise
+func (w window) activeUntil(Now time.Time) (time.Time, bool) {
+	if w.cron == nil {
+		return w.end, !Now.Before(w.start) && Now.Before(w.end)
+	}
+	t := Now.Truncate(time.Minute)
+	for earliest := Now.Add(-w.duration); t.After(earliest); t = t.Add(-time.Minute) {
+		if w.cron.matches(t) {
+			return t.Add(w.duration), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Find an active maintenance window for the Scope. If several windows are
+// active, the one lasting longest is returned.
+func (s *Schedule) Active(Scope Scope, Now time.Time) (string, time.Time, bool) {
+	logger := log.With().Str("func", "Active").Str("package", "maintenance").Logger()
+	logger.Trace().Msg("Enter func")
+
+	name := ""
+	until := time.Time{}
+	found := false
+	if s == nil {
+		return name, until, found
+	}
+	for _, w := range s.windows {
+		if !matches(w.devices, Scope.Device) || !matches(w.pools, Scope.Pool) || !matches(w.members, Scope.Member) {
+			continue
+		}
+		end, ok := w.activeUntil(Now)
+		if !ok {
+			continue
+		}
+		logger.Debug().Str("id", "DBG12002001").
+			Str("window", w.name).
+			Str("device", Scope.Device).
+			Str("pool", Scope.Pool).
+			Str("member", Scope.Member).
+			Time("until", end).
+			Msg("Maintenance window active")
+		if !found || end.After(until) {
+			name = w.name
+			until = end
+			found = true
+		}
+	}
+	return name, until, found
+}
+
+// The annotation for problems within an active maintenance window, e.g. "in
+// maintenance until 02:00". An empty string means no window is active.
+func (s *Schedule) Annotation(Scope Scope, Now time.Time) string {
+	_, until, ok := s.Active(Scope, Now)
+	if !ok {
+		return ""
+	}
+	until = until.Local()
+	now := Now.Local()
+	if until.Year() == now.Year() && until.YearDay() == now.YearDay() {
+		return "in maintenance until " + until.Format("15:04")
+	}
+	return "in maintenance until " + until.Format("2006-01-02 15:04")
+}
+
+// A parsed cron-like schedule, every field is the set of allowed values
+type cron struct {
+	minute map[int]bool
+	hour   map[int]bool
+	dom    map[int]bool
+	month  map[int]bool
+	dow    map[int]bool
+	anyDom bool
+	anyDow bool
+}
+
+// Parse a schedule "minute hour day-of-month month day-of-week". Every field
+// may be "*", a number, a range "a-b", a list "a,b" and have a step "/n".
+// Like in cron, a day matches if either the day of month or the day of week
+// matches when both are restricted. Sunday is 0 or 7.
+func parseCron(Schedule string) (*cron, error) {
+	fields := strings.Fields(Schedule)
+	if len(fields) != 5 {
+		return nil, errors.New("schedule " + Schedule + " needs five fields: minute hour day-of-month month day-of-week")
+	}
+	c := new(cron)
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+	return c, nil
+}
+
+// Parse a single field of a schedule into the set of allowed values
+func parseCronField(Field string, Min int, Max int) (map[int]bool, error) {
+	result := make(map[int]bool)
+	for _, part := range strings.Split(Field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, errors.New("invalid step in " + Field)
+			}
+			part = part[:i]
+		}
+		from, to := Min, Max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			f, errFrom := strconv.Atoi(bounds[0])
+			t, errTo := strconv.Atoi(bounds[1])
+			if errFrom != nil || errTo != nil {
+				return nil, errors.New("invalid range in " + Field)
+			}
+			from, to = f, t
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, errors.New("invalid value in " + Field)
+			}
+			from, to = v, v
+		}
+		if from < Min || to > Max || from > to {
+			return nil, fmt.Errorf("%v is out of the range %d-%d", Field, Min, Max)
+		}
+		for v := from; v <= to; v += step {
+			result[v] = true
+		}
+	}
+	return result, nil
+}
+
+// Check whether the schedule starts at the given minute
+func (c *cron) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
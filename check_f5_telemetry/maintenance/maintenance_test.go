@@ -0,0 +1,109 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		schedule string
+		wantErr  bool
+	}{
+		{"0 2 * * *", false},
+		{"*/15 1-3 1,15 * 0", false},
+		{"0 2 * * 7", false},
+		{"30 22 * 1-12/2 1-5", false},
+		{"0 2 * *", true},
+		{"0 2 * * * *", true},
+		{"60 2 * * *", true},
+		{"0 24 * * *", true},
+		{"0 2 0 * *", true},
+		{"0 2 * 13 *", true},
+		{"0 2 * * 8", true},
+		{"5x 2 * * *", true},
+		{"0 2x * * *", true},
+		{"0 1-3x * * *", true},
+		{"0 x-3 * * *", true},
+		{"*/0 2 * * *", true},
+		{"*/2x 2 * * *", true},
+		{"0 5-3 * * *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			_, err := parseCron(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCron(%q) error = %v, wantErr %v", tt.schedule, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2024-03-01 is a Friday, 2024-03-03 a Sunday, 2024-03-15 a Friday
+	tests := []struct {
+		name     string
+		schedule string
+		time     time.Time
+		want     bool
+	}{
+		{"every day", "0 2 * * *", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), true},
+		{"wrong minute", "0 2 * * *", time.Date(2024, 3, 1, 2, 1, 0, 0, time.UTC), false},
+		{"wrong hour", "0 2 * * *", time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC), false},
+		{"step", "*/15 * * * *", time.Date(2024, 3, 1, 4, 45, 0, 0, time.UTC), true},
+		{"step mismatch", "*/15 * * * *", time.Date(2024, 3, 1, 4, 40, 0, 0, time.UTC), false},
+		{"sunday as 0", "0 2 * * 0", time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC), true},
+		{"sunday as 7", "0 2 * * 7", time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC), true},
+		{"day of week only", "0 2 * * 0", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), false},
+		{"day of month only", "0 2 15 * *", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), false},
+		{"dom or dow, dom matches", "0 2 1 * 0", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), true},
+		{"dom or dow, dow matches", "0 2 1 * 0", time.Date(2024, 3, 3, 2, 0, 0, 0, time.UTC), true},
+		{"dom or dow, neither matches", "0 2 1 * 0", time.Date(2024, 3, 15, 2, 0, 0, 0, time.UTC), false},
+		{"month", "0 2 * 4 *", time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.schedule)
+			if err != nil {
+				t.Fatalf("parseCron(%q) error = %v", tt.schedule, err)
+			}
+			if got := c.matches(tt.time); got != tt.want {
+				t.Errorf("%q matches %v = %v, want %v", tt.schedule, tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActiveUntil(t *testing.T) {
+	daily, err := parseCron("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 2, 0, 0, 0, time.UTC)
+	scheduled := window{name: "daily", cron: daily, duration: 2 * time.Hour}
+	fixed := window{name: "fixed", start: start, end: start.Add(time.Hour)}
+	tests := []struct {
+		name      string
+		window    window
+		now       time.Time
+		wantUntil time.Time
+		wantOk    bool
+	}{
+		{"schedule start", scheduled, start, start.Add(2 * time.Hour), true},
+		{"schedule within", scheduled, start.Add(90*time.Minute + 30*time.Second), start.Add(2 * time.Hour), true},
+		{"schedule end", scheduled, start.Add(2 * time.Hour), time.Time{}, false},
+		{"schedule before", scheduled, start.Add(-time.Minute), time.Time{}, false},
+		{"fixed start", fixed, start, start.Add(time.Hour), true},
+		{"fixed within", fixed, start.Add(30 * time.Minute), start.Add(time.Hour), true},
+		{"fixed end", fixed, start.Add(time.Hour), start.Add(time.Hour), false},
+		{"fixed before", fixed, start.Add(-time.Second), start.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, ok := tt.window.activeUntil(tt.now)
+			if ok != tt.wantOk || (ok && !until.Equal(tt.wantUntil)) {
+				t.Errorf("activeUntil(%v) = %v, %v, want %v, %v", tt.now, until, ok, tt.wantUntil, tt.wantOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package pool
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The maximum number of documents fetched for the history
+const historySize = 10000
+
+// A change of the availability of a member
+type Transition struct {
+	Timestamp time.Time
+	State     string
+}
+
+// Fetch the availability history of the members over the last Window and
+// raise a warning for every member whose availability changed more than
+// Threshold times. The output shows the timeline of the transitions. If the
+// window contains more than historySize documents, only the newest ones are
+// evaluated and the result is UNKNOWN.
+func (p *Pool) CheckFlapping(Window time.Duration, Threshold int) {
+	logger := log.With().Str("func", "CheckFlapping").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	q := fmt.Sprintf("{\"size\":%d,\"sort\":{\"@timestamp\":\"desc\"},\"query\":%v,\"fields\":[\"@timestamp\",\"pools.%v.members.*.availabilityState.keyword\"],\"_source\":false}",
+		historySize, p.connection.Filter(trend.RangeQuery(Window)), p.pool)
+	data, err := p.connection.Search(p.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR10100001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, p.index, q))
+		return
+	}
+	if len(data.Hits.Hits) >= historySize {
+		logger.Warn().Str("id", "WRN10100001").Int("size", historySize).Msg("History truncated, use a shorter window")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: more than %v documents in the last %v, only the newest are evaluated. Use a shorter window",
+			historySize, trend.FormatWindow(Window)))
+	}
+
+	// The newest documents were fetched to keep them if the history is
+	// truncated, the transitions are counted from the oldest one
+	hits := data.Hits.Hits
+	for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+		hits[i], hits[j] = hits[j], hits[i]
+	}
+
+	field := regexp.MustCompile("^pools\\." + regexp.QuoteMeta(p.pool) + "\\.members\\.(.*)\\.availabilityState\\.keyword$")
+	last := make(map[string]string)
+	timelines := make(map[string][]Transition)
+	for _, hit := range hits {
+		t := memberString(hit.Fields, "@timestamp")
+		ts, err := parseTimestamp(t)
+		if err != nil {
+			logger.Warn().Str("id", "WRN10100002").Str("value", t).Err(err).Msg("Could not parse timestamp")
+			continue
+		}
+		for name := range hit.Fields {
+			match := field.FindStringSubmatch(name)
+			if match == nil || !p.memberSelected(match[1]) {
+				continue
+			}
+			state := memberString(hit.Fields, name)
+			previous, found := last[match[1]]
+			last[match[1]] = state
+			if found && previous != state {
+				timelines[match[1]] = append(timelines[match[1]], Transition{ts, state})
+			}
+		}
+	}
+
+	members := make([]string, 0, len(timelines))
+	for member := range timelines {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	flapping := 0
+	for _, member := range members {
+		transitions := timelines[member]
+		logger.Debug().Str("id", "DBG10100001").
+			Str("member", member).
+			Int("transitions", len(transitions)).
+			Msg("Transitions counted")
+		if len(transitions) <= Threshold {
+			continue
+		}
+		flapping++
+		timeline := make([]string, 0, len(transitions))
+		for _, t := range transitions {
+			timeline = append(timeline, t.Timestamp.Local().Format("15:04:05")+" "+t.State)
+		}
+		p.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: member %v changed its availability %v times in the last %v: %v",
+			member, len(transitions), trend.FormatWindow(Window), strings.Join(timeline, ", ")))
+	}
+	if flapping == 0 {
+		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: no member changed its availability more than %v times in the last %v", Threshold, trend.FormatWindow(Window)))
+	}
+
+	min := float64(0)
+	v, _ := nagiosplugin.NewFloatPerfDatumValue(float64(flapping))
+	p.nagios.AddPerfDatum("flapping_members", "", v, nil, nil, &min, nil)
+}
+
+// Parse a timestamp in the format of @timestamp, falling back to RFC3339 with
+// any precision and to milliseconds since the epoch
+func parseTimestamp(Value string) (time.Time, error) {
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z", Value)
+	if err == nil {
+		return ts, nil
+	}
+	if ts, err = time.Parse(time.RFC3339Nano, Value); err == nil {
+		return ts, nil
+	}
+	if ms, e := strconv.ParseFloat(Value, 64); e == nil {
+		return time.UnixMilli(int64(ms)).UTC(), nil
+	}
+	return ts, err
+}
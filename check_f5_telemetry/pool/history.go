@@ -0,0 +1,63 @@
+package pool
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Check for how many percent of the documents in the last Window the pool
+// was not available. Warn and Crit are ranges for this percentage.
+func (p *Pool) CheckUnavailable(Window time.Duration, Warn string, Crit string) {
+	logger := log.With().Str("func", "CheckUnavailable").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	field := "pools." + p.pool + ".availabilityState.keyword"
+	query := fmt.Sprintf("{\"size\":0,\"query\":%v,\"aggs\":{"+
+		"\"total\":{\"filter\":{\"exists\":{\"field\":\"%v\"}}},"+
+		"\"unavailable\":{\"filter\":{\"bool\":{\"filter\":[{\"exists\":{\"field\":\"%v\"}}],\"must_not\":[{\"term\":{\"%v\":\"available\"}}]}}}}}",
+		p.connection.Filter(trend.RangeQuery(Window)), field, field, field)
+	data, err := p.connection.Search(p.index, query)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR10090001").
+			Str("query", query).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, p.index, query))
+		return
+	}
+	total := trend.DocCount(data, "total")
+	if total == 0 {
+		logger.Error().Str("id", "ERR10090002").Str("window", Window.String()).Msg("No pool data in the window")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No data for pool %v in the last %v", p.pool, trend.FormatWindow(Window)))
+		return
+	}
+	unavailable := trend.DocCount(data, "unavailable") / total * 100
+	logger.Debug().Str("id", "DBG10090001").
+		Float64("total", total).
+		Float64("unavailable", unavailable).
+		Msg("Unavailability calculated")
+
+	msg := fmt.Sprintf("pool unavailable %.1f%% of the last %v", unavailable, trend.FormatWindow(Window))
+	if threshold.CheckRange(p.nagios, Crit, unavailable, "critical") {
+		p.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: "+msg)
+	} else if threshold.CheckRange(p.nagios, Warn, unavailable, "warning") {
+		p.nagios.AddResult(nagiosplugin.WARNING, "WARNING: "+msg)
+	} else {
+		p.nagios.AddResult(nagiosplugin.OK, "OK: "+msg)
+	}
+
+	min := float64(0)
+	max := float64(100)
+	v, _ := nagiosplugin.NewFloatPerfDatumValue(unavailable)
+	p.nagios.AddPerfDatum("unavailable", "%", v, threshold.PerfRange(Warn), threshold.PerfRange(Crit), &min, &max)
+}
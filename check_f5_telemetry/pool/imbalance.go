@@ -0,0 +1,107 @@
+package pool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics which can be used to detect an imbalance between the pool members
+const (
+	ImbalanceConnections = "connections"
+	ImbalanceBits        = "bits"
+)
+
+// Check, if the metric used for the imbalance check is known
+func ValidateImbalanceMetric(Metric string) error {
+	switch Metric {
+	case ImbalanceConnections, ImbalanceBits:
+		return nil
+	}
+	return errors.New("Illegal imbalance metric " + Metric + ", use " + ImbalanceConnections + " or " + ImbalanceBits)
+}
+
+// The value of the imbalance metric for a member. Connections are the current
+// server side connections, bits are the server side bits in and out per
+// second between the two latest documents.
+func (m PoolMemberData) imbalanceValue(Metric string) float64 {
+	if Metric == ImbalanceBits {
+		return m.BitRate
+	}
+	return m.CurrentConnections
+}
+
+// Check, whether connections or traffic are evenly distributed over the
+// members able to receive traffic. Warn and Crit are ranges for the
+// percentage the busiest member is above the mean. Available members
+// receiving nothing raise a warning as long as the mean per member is at
+// least IdleMean, so quiet pools can be excluded. A negative IdleMean turns
+// the warning off.
+func (p *Pool) CheckImbalance(s *PoolState, Metric string, Warn string, Crit string, IdleMean float64) {
+	logger := log.With().Str("func", "CheckImbalance").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	var total float64
+	active := make([]string, 0, len(s.Members))
+	for _, member := range s.Members.Names() {
+		m := s.Members[member]
+		if m.AvailabilityState != "available" || m.EnabledState != "enabled" {
+			continue
+		}
+		if Metric == ImbalanceBits && !m.HasBitRate {
+			logger.Debug().Str("id", "DBG10070003").Str("member", member).Msg("No bit rate for member")
+			continue
+		}
+		active = append(active, member)
+		total += m.imbalanceValue(Metric)
+	}
+	if Metric == ImbalanceBits && len(active) == 0 {
+		logger.Error().Str("id", "ERR10070001").Msg("No bit rates for the members")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, "Could not calculate the bit rates of the members, two documents of the pool are needed")
+		return
+	}
+	if len(active) < 2 || total == 0 {
+		logger.Debug().Str("id", "DBG10070001").
+			Int("active", len(active)).
+			Float64("total", total).
+			Msg("Not enough members or traffic to evaluate imbalance")
+		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: no %v to compare between members", Metric))
+		return
+	}
+
+	mean := total / float64(len(active))
+	deviation := math.Inf(-1)
+	busiest := ""
+	for _, member := range active {
+		v := s.Members[member].imbalanceValue(Metric)
+		d := (v - mean) / mean * 100
+		if d > deviation {
+			deviation = d
+			busiest = member
+		}
+		if v == 0 && IdleMean >= 0 && mean >= IdleMean {
+			p.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: member %v is available but receives no %v", member, Metric))
+		}
+	}
+	logger.Debug().Str("id", "DBG10070002").
+		Str("member", busiest).
+		Float64("mean", mean).
+		Float64("deviation", deviation).
+		Msg("Imbalance calculated")
+
+	msg := fmt.Sprintf("member %v carries %.1f%% more %v than the mean (%.0f of %.0f)", busiest, deviation, Metric, s.Members[busiest].imbalanceValue(Metric), total)
+	if threshold.CheckRange(p.nagios, Crit, deviation, "critical") {
+		p.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: "+msg)
+	} else if threshold.CheckRange(p.nagios, Warn, deviation, "warning") {
+		p.nagios.AddResult(nagiosplugin.WARNING, "WARNING: "+msg)
+	} else {
+		p.nagios.AddResult(nagiosplugin.OK, "OK: "+msg)
+	}
+
+	v, _ := nagiosplugin.NewFloatPerfDatumValue(deviation)
+	p.nagios.AddPerfDatum("imbalance", "%", v, threshold.PerfRange(Warn), threshold.PerfRange(Crit), nil, nil)
+}
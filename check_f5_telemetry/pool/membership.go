@@ -0,0 +1,223 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The members of a pool at a point in time
+type MembershipSnapshot struct {
+	Timestamp time.Time `yaml:"Timestamp" json:"Timestamp"`
+	Members   []string  `yaml:"Members" json:"Members"`
+}
+
+// The snapshots stored in a state file, indexed by MembershipKey
+type MembershipState map[string]MembershipSnapshot
+
+// The key of the snapshot of the Pool on the Device in the state file. Without
+// a device, the pool name alone is used.
+func MembershipKey(Device string, Pool string) string {
+	if Device == "" {
+		return Pool
+	}
+	return Device + ":" + Pool
+}
+
+// Compare the members of the pool with the snapshot stored in StateFile and
+// warn about members which appeared or disappeared. If the file has no
+// snapshot of the pool yet or Accept is set, the current members are stored
+// as the new snapshot. The snapshot is never updated otherwise, so a change
+// is reported until it is accepted. The state file is locked while it is
+// read and written, so checks of other pools sharing it don't lose updates.
+func (p *Pool) CheckMembershipState(s *PoolState, StateFile string, Accept bool) {
+	logger := log.With().Str("func", "CheckMembershipState").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	lock, err := lockMembershipState(StateFile)
+	if err != nil {
+		logger.Error().Str("id", "ERR10110006").Str("file", StateFile).Err(err).Msg("Could not lock membership state")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not lock membership state %v: %v", StateFile, err))
+		return
+	}
+	defer lock.Close()
+
+	state, err := ReadMembershipState(StateFile)
+	if err != nil {
+		logger.Error().Str("id", "ERR10110001").Str("file", StateFile).Err(err).Msg("Could not read membership state")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not read membership state %v: %v", StateFile, err))
+		return
+	}
+	current := MembershipSnapshot{Timestamp: s.Timestamp, Members: s.Members.Names()}
+	key := MembershipKey(p.connection.Device(), p.pool)
+	snapshot, found := state[key]
+	if !found || Accept {
+		state[key] = current
+		if err = state.Write(StateFile); err != nil {
+			logger.Error().Str("id", "ERR10110002").Str("file", StateFile).Err(err).Msg("Could not write membership state")
+			p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not write membership state %v: %v", StateFile, err))
+			return
+		}
+		logger.Info().Str("id", "INF10110001").
+			Str("file", StateFile).
+			Str("key", key).
+			Int("members", len(current.Members)).
+			Msg("Membership snapshot stored")
+		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: stored the %v members as membership snapshot", len(current.Members)))
+		p.membershipPerfdata(0, 0)
+		return
+	}
+	p.compareMembership(current.Members, snapshot.Members,
+		"the snapshot from "+snapshot.Timestamp.Local().Format("2006-01-02 15:04:05"))
+}
+
+// Compare the members of the pool with the newest document which is at
+// least Age old and warn about members which appeared or disappeared since.
+func (p *Pool) CheckMembershipHistory(s *PoolState, Age time.Duration) {
+	logger := log.With().Str("func", "CheckMembershipHistory").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	older := fmt.Sprintf("{\"range\":{\"@timestamp\":{\"lte\":\"now-%ds\"}}}", int64(Age.Seconds()))
+	q := fmt.Sprintf("{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":%v,"+
+		"\"fields\":[\"@timestamp\",\"pools.%v.availabilityState.keyword\",\"pools.%v.members.*.enabledState.keyword\"],\"_source\":false}",
+		p.connection.Filter(older), p.pool, p.pool)
+	data, err := p.connection.Search(p.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR10110003").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, p.index, q))
+		return
+	}
+	if len(data.Hits.Hits) == 0 || data.Hits.Hits[0].Fields["pools."+p.pool+".availabilityState.keyword"] == nil {
+		logger.Error().Str("id", "ERR10110004").Str("age", Age.String()).Msg("No pool data older than the age")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No data for pool %v older than %v", p.pool, trend.FormatWindow(Age)))
+		return
+	}
+	fields := data.Hits.Hits[0].Fields
+	names, err := MemberNames(fields, p.pool)
+	if err != nil {
+		logger.Error().Str("id", "ERR10110005").Err(err).Msg("Could not compile regex")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+		return
+	}
+	previous := make([]string, 0, len(names))
+	for _, member := range names {
+		if p.memberSelected(member) {
+			previous = append(previous, member)
+		}
+	}
+	since := "the document from " + memberString(fields, "@timestamp")
+	if ts, err := parseTimestamp(memberString(fields, "@timestamp")); err == nil {
+		since = "the document from " + ts.Local().Format("2006-01-02 15:04:05")
+	}
+	p.compareMembership(s.Members.Names(), previous, since)
+}
+
+// Add a warning listing the members added and removed since the Previous
+// members, or an ok result if the members didn't change.
+func (p *Pool) compareMembership(Current []string, Previous []string, Since string) {
+	logger := log.With().Str("func", "compareMembership").Str("package", "pool").Str("pool", p.pool).Logger()
+
+	added, removed := MembershipChanges(Current, Previous)
+	logger.Debug().Str("id", "DBG10110001").
+		Strs("added", added).
+		Strs("removed", removed).
+		Str("since", Since).
+		Msg("Membership compared")
+	if len(added) > 0 {
+		p.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v members added since %v: %v", len(added), Since, strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		p.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v members removed since %v: %v", len(removed), Since, strings.Join(removed, ", ")))
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: members unchanged since %v", Since))
+	}
+	p.membershipPerfdata(len(added), len(removed))
+}
+
+// Add the number of added and removed members to the performance data
+func (p *Pool) membershipPerfdata(Added int, Removed int) {
+	min := float64(0)
+	v, _ := nagiosplugin.NewFloatPerfDatumValue(float64(Added))
+	p.nagios.AddPerfDatum("members_added", "", v, nil, nil, &min, nil)
+	v, _ = nagiosplugin.NewFloatPerfDatumValue(float64(Removed))
+	p.nagios.AddPerfDatum("members_removed", "", v, nil, nil, &min, nil)
+}
+
+// The members in Current but not in Previous (added) and the members in
+// Previous but not in Current (removed), both sorted alphabetically
+func MembershipChanges(Current []string, Previous []string) ([]string, []string) {
+	diff := func(a []string, b []string) []string {
+		known := make(map[string]bool, len(b))
+		for _, m := range b {
+			known[m] = true
+		}
+		result := make([]string, 0)
+		for _, m := range a {
+			if !known[m] {
+				result = append(result, m)
+			}
+		}
+		sort.Strings(result)
+		return result
+	}
+	return diff(Current, Previous), diff(Previous, Current)
+}
+
+// Read the membership snapshots from the state file. A missing file is
+// treated as an empty state.
+func ReadMembershipState(File string) (MembershipState, error) {
+	state := make(MembershipState)
+	content, err := os.ReadFile(File)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Write the membership snapshots to the state file. The file is replaced
+// atomically, so a check running at the same time never reads a partial
+// file.
+func (m MembershipState) Write(File string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(File), filepath.Base(File)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), File)
+}
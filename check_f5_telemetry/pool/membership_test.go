@@ -0,0 +1,89 @@
+package pool
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestMembershipChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     []string
+		previous    []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{"unchanged", []string{"/Common/a:80", "/Common/b:80"}, []string{"/Common/b:80", "/Common/a:80"}, []string{}, []string{}},
+		{"added", []string{"/Common/c:80", "/Common/a:80", "/Common/b:80"}, []string{"/Common/a:80"}, []string{"/Common/b:80", "/Common/c:80"}, []string{}},
+		{"removed", []string{"/Common/a:80"}, []string{"/Common/c:80", "/Common/a:80", "/Common/b:80"}, []string{}, []string{"/Common/b:80", "/Common/c:80"}},
+		{"replaced", []string{"/Common/b:80"}, []string{"/Common/a:80"}, []string{"/Common/b:80"}, []string{"/Common/a:80"}},
+		{"empty previous", []string{"/Common/a:80"}, nil, []string{"/Common/a:80"}, []string{}},
+		{"empty current", nil, []string{"/Common/a:80"}, []string{}, []string{"/Common/a:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := MembershipChanges(tt.current, tt.previous)
+			if !reflect.DeepEqual(added, tt.wantAdded) || !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("MembershipChanges() = %v, %v, want %v, %v", added, removed, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestMembershipKey(t *testing.T) {
+	tests := []struct {
+		device string
+		pool   string
+		want   string
+	}{
+		{"", "/Common/web-pool", "/Common/web-pool"},
+		{"bigip1.example.com", "/Common/web-pool", "bigip1.example.com:/Common/web-pool"},
+		{"bigip2.example.com", "/Common/web-pool", "bigip2.example.com:/Common/web-pool"},
+	}
+	for _, tt := range tests {
+		if got := MembershipKey(tt.device, tt.pool); got != tt.want {
+			t.Errorf("MembershipKey(%q, %q) = %q, want %q", tt.device, tt.pool, got, tt.want)
+		}
+	}
+}
+
+func TestMembershipStateConcurrentUpdates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the state file is not locked on windows")
+	}
+	file := filepath.Join(t.TempDir(), "membership.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := lockMembershipState(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer lock.Close()
+			state, err := ReadMembershipState(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			state[fmt.Sprintf("/Common/pool%v", i)] = MembershipSnapshot{Members: []string{"/Common/a:80"}}
+			if err = state.Write(file); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	state, err := ReadMembershipState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state) != 20 {
+		t.Errorf("state has %v snapshots, want 20", len(state))
+	}
+}
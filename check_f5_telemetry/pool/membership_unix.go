@@ -0,0 +1,22 @@
+//go:build !windows
+
+package pool
+
+import (
+	"os"
+	"syscall"
+)
+
+// Take an exclusive lock on the lock file next to the state File, waiting
+// for other checks holding it. Closing the returned file releases the lock.
+func lockMembershipState(File string) (*os.File, error) {
+	lock, err := os.OpenFile(File+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
@@ -0,0 +1,10 @@
+package pool
+
+import "os"
+
+// Open the lock file next to the state File. Windows has no flock, so the
+// checks sharing a state file are not serialized and closing the returned
+// file only closes it.
+func lockMembershipState(File string) (*os.File, error) {
+	return os.OpenFile(File+".lock", os.O_CREATE|os.O_RDWR, 0644)
+}
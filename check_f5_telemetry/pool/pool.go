@@ -3,6 +3,7 @@ package pool
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	//"github.com/davecgh/go-spew/spew"
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
 	"github.com/joernott/nagiosplugin/v2"
 
 	"github.com/rs/zerolog/log"
@@ -22,19 +24,62 @@ type Pool struct {
 	index           string
 	pool            string
 	ignore_disabled bool
+	members         MemberOptions
+	include         *regexp.Regexp
+	exclude         *regexp.Regexp
 	connection      *elasticsearch.Elasticsearch
 	nagios          *nagiosplugin.Check
 }
 
+// Options controlling which pool members are evaluated and how they are
+// reported. Include and Exclude are regular expressions matched against the
+// member name, an empty string disables the filter. Severity is the status
+// used for unavailable members. With ProblemsOnly set, members which are ok
+// are not listed in the output. Perfdata adds connections and traffic
+// performance data for every member. Maintenance returns an annotation like
+// "in maintenance until 02:00" for members in a maintenance window, their
+// problems are reported as OK.
+type MemberOptions struct {
+	Include      string
+	Exclude      string
+	Severity     nagiosplugin.Status
+	ProblemsOnly bool
+	Perfdata     bool
+	Maintenance  func(Member string) string
+}
+
 // Pool member data
 type PoolMemberData struct {
-	AvailabilityState string
-	EnabledState      string
+	AvailabilityState  string
+	EnabledState       string
+	MonitorStatus      string
+	StatusReason       string
+	Address            string
+	Port               string
+	CurrentConnections float64
+	MaxConnections     float64
+	PacketsIn          float64
+	PacketsOut         float64
+	BitsIn             float64
+	BitsOut            float64
+	BitRate            float64
+	HasBitRate         bool
+	Maintenance        string
 }
 
 // States of the various pool members
 type PoolMemberState map[string]PoolMemberData
 
+// The member names, sorted alphabetically
+func (m PoolMemberState) Names() []string {
+	names := make([]string, 0, len(m))
+	for member := range m {
+		names = append(names, member)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Consolidated states of the pool
 type PoolState struct {
 	AvailabilityState   string
@@ -50,13 +95,43 @@ type PoolState struct {
 	DownMemberCount     uint
 	DisabledMemberCount uint
 	UnavailableMembers  uint
+	MaintenanceMembers  uint
 	TotalMembers        uint
 }
 
+// Add a member to the pool state and update the member counters. With
+// IgnoreDisabled set, disabled members count as unavailable. Members in
+// maintenance never count as unavailable.
+func (s *PoolState) AddMember(Name string, Member PoolMemberData, IgnoreDisabled bool) {
+	if s.Members == nil {
+		s.Members = make(PoolMemberState)
+	}
+	if Member.EnabledState != "enabled" {
+		s.DisabledMemberCount++
+	}
+	if Member.AvailabilityState != "available" {
+		s.DownMemberCount++
+	}
+	if Member.Maintenance != "" {
+		s.MaintenanceMembers++
+	} else if IgnoreDisabled {
+		if Member.AvailabilityState != "available" || Member.EnabledState != "enabled" {
+			s.UnavailableMembers++
+		}
+	} else {
+		if Member.EnabledState == "enabled" && Member.AvailabilityState != "available" {
+			s.UnavailableMembers++
+		}
+	}
+	s.TotalMembers++
+	s.Members[Name] = Member
+}
+
 // Creates a Pool object containing the connection object to Elasticsearch, a
 // Nagios object, the Index and pool name
-func NewPool(Index string, PoolName string, IgnoreDisabled bool, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Pool, error) {
+func NewPool(Index string, PoolName string, IgnoreDisabled bool, Members MemberOptions, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Pool, error) {
 	var p *Pool
+	var err error
 
 	logger := log.With().Str("func", "NewCheck").Str("package", "pool").Logger()
 	logger.Trace().Msg("Enter func")
@@ -64,17 +139,63 @@ func NewPool(Index string, PoolName string, IgnoreDisabled bool, Connection *ela
 	p.index = Index
 	p.pool = PoolName
 	p.ignore_disabled = IgnoreDisabled
+	p.members = Members
+	if p.members.Severity == nagiosplugin.OK {
+		p.members.Severity = nagiosplugin.WARNING
+	}
+	if Members.Include != "" {
+		if p.include, err = regexp.Compile(Members.Include); err != nil {
+			logger.Error().Str("id", "ERR10010001").
+				Str("regex", Members.Include).
+				Err(err).
+				Msg("Could not compile member include pattern")
+			return nil, err
+		}
+	}
+	if Members.Exclude != "" {
+		if p.exclude, err = regexp.Compile(Members.Exclude); err != nil {
+			logger.Error().Str("id", "ERR10010002").
+				Str("regex", Members.Exclude).
+				Err(err).
+				Msg("Could not compile member exclude pattern")
+			return nil, err
+		}
+	}
 	p.connection = Connection
 	p.nagios = Nagios
 
 	return p, nil
 }
 
-// Execute the query
+// Parse the severity used for unavailable pool members. Only "warning" and
+// "critical" are accepted, an empty string defaults to warning.
+func ParseSeverity(Severity string) (nagiosplugin.Status, error) {
+	switch strings.ToUpper(Severity) {
+	case "", "WARNING":
+		return nagiosplugin.WARNING, nil
+	case "CRITICAL":
+		return nagiosplugin.CRITICAL, nil
+	}
+	return nagiosplugin.UNKNOWN, errors.New("Illegal member severity " + Severity)
+}
+
+// Check, whether a member passes the include and exclude patterns
+func (p *Pool) memberSelected(member string) bool {
+	if p.include != nil && !p.include.MatchString(member) {
+		return false
+	}
+	if p.exclude != nil && p.exclude.MatchString(member) {
+		return false
+	}
+	return true
+}
+
+// Execute the query, fetching the two latest documents to calculate the bit
+// rates of the members
 func (p *Pool) Execute() (*PoolState, error) {
 	logger := log.With().Str("func", "Execute").Str("package", "pool").Logger()
 	logger.Trace().Msg("Enter func")
-	q := strings.ReplaceAll("{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":{\"match_all\":{}},\"fields\":[\"@timestamp\",\"pools._POOL_.*\"],\"_source\":false}", "_POOL_", p.pool)
+	q := strings.ReplaceAll("{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + p.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"pools._POOL_.*\"],\"_source\":false}", "_POOL_", p.pool)
 	data, err := p.connection.Search(p.index, q)
 	if err != nil {
 		reason := ""
@@ -96,6 +217,11 @@ func (p *Pool) Execute() (*PoolState, error) {
 	return s, nil
 }
 
+// Convert the two latest documents of the pool, see Execute
+func (p *Pool) Gather(e *elasticsearch.ElasticsearchResult) (*PoolState, error) {
+	return p.gatherPoolState(e)
+}
+
 // Convert the Elasticsearch data into our data structure
 func (p *Pool) gatherPoolState(e *elasticsearch.ElasticsearchResult) (*PoolState, error) {
 	var s *PoolState
@@ -159,59 +285,174 @@ func (p *Pool) gatherPoolState(e *elasticsearch.ElasticsearchResult) (*PoolState
 	}
 	s.ActiveMemberCount = uint(amc)
 	s.Members = make(PoolMemberState)
-	for f, _ := range fields {
-		r := "pools\\." + p.pool + ".members\\..*\\.enabledState.keyword"
-		match, err := regexp.MatchString(r, f)
-		if err != nil {
-			logger.Error().Str("id", "ERR10030004").
-				Str("field", f).
-				Str("regex", r).
-				Err(err).
-				Msg("Could not match regex")
-			return nil, err
-		}
-		if match {
-			n := strings.Split(f, ".")
-			member := n[3]
-			e := fmt.Sprintf("%v", fields["pools."+p.pool+".members."+member+".enabledState.keyword"].([]interface{})[0])
-			if e != "enabled" {
-				s.DisabledMemberCount++
-			}
-			a := fmt.Sprintf("%v", fields["pools."+p.pool+".members."+member+".availabilityState.keyword"].([]interface{})[0])
-			if a != "available" {
-				s.DownMemberCount++
-			}
-			if p.ignore_disabled {
-				if a != "available" || e != "enabled" {
-					s.UnavailableMembers++
-				}
-			} else {
-				if e == "enabled" && a != "available" {
-					s.UnavailableMembers++
-				}
-			}
-			m := PoolMemberData{a, e}
-			s.TotalMembers++
-			logger.Debug().Str("id", "DBG10030001").
-				Bool("match", true).
-				Str("field", f).
-				Str("regex", r).
+	members, err := MemberNames(fields, p.pool)
+	if err != nil {
+		logger.Error().Str("id", "ERR10030004").
+			Err(err).
+			Msg("Could not compile regex")
+		return nil, err
+	}
+	for _, member := range members {
+		if !p.memberSelected(member) {
+			logger.Debug().Str("id", "DBG10030003").
 				Str("member", member).
-				Str("availabilityState", a).
-				Str("enabledState", e).
-				Msg("Match found")
-			s.Members[member] = m
-		} else {
-			logger.Trace().Str("id", "DBG10030002").
-				Bool("match", false).
-				Str("field", f).
-				Str("regex", r).
-				Msg("NoMatch")
+				Msg("Member filtered out")
+			continue
 		}
+		m := p.getMember(fields, member)
+		if p.members.Maintenance != nil {
+			m.Maintenance = p.members.Maintenance(member)
+		}
+		s.AddMember(member, m, p.ignore_disabled)
+		logger.Debug().Str("id", "DBG10030001").
+			Bool("match", true).
+			Str("member", member).
+			Str("availabilityState", m.AvailabilityState).
+			Str("enabledState", m.EnabledState).
+			Str("monitorStatus", m.MonitorStatus).
+			Str("statusReason", m.StatusReason).
+			Msg("Match found")
 	}
+	p.gatherBitRates(s, e)
 	return s, nil
 }
 
+// Calculate the bit rates of the members from the counters in the two latest
+// documents. Members missing in the older document or whose counters have
+// been reset get no rate.
+func (p *Pool) gatherBitRates(s *PoolState, e *elasticsearch.ElasticsearchResult) {
+	logger := log.With().Str("func", "gatherBitRates").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) < 2 {
+		logger.Debug().Str("id", "DBG10030004").Msg("Only one document, can't calculate bit rates")
+		return
+	}
+	fields := e.Hits.Hits[1].Fields
+	t := memberString(fields, "@timestamp")
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z", t)
+	if err != nil {
+		logger.Warn().Str("id", "WRN10030001").Str("value", t).Err(err).Msg("Could not parse timestamp of the previous document")
+		return
+	}
+	seconds := s.Timestamp.Sub(ts).Seconds()
+	if seconds <= 0 {
+		logger.Warn().Str("id", "WRN10030002").Msg("Documents have the same timestamp, can't calculate bit rates")
+		return
+	}
+	for name, m := range s.Members {
+		prefix := "pools." + p.pool + ".members." + name + ".serverside."
+		bitsIn, okIn := memberFloat(fields, prefix+"bitsIn")
+		bitsOut, okOut := memberFloat(fields, prefix+"bitsOut")
+		if !okIn || !okOut {
+			continue
+		}
+		if m.BitsIn < bitsIn || m.BitsOut < bitsOut {
+			logger.Info().Str("id", "INF10030001").Str("member", name).Msg("Counters have been reset")
+			continue
+		}
+		m.BitRate = (m.BitsIn - bitsIn + m.BitsOut - bitsOut) / seconds
+		m.HasBitRate = true
+		s.Members[name] = m
+	}
+}
+
+// The names of all pools in the fields of a document, sorted alphabetically
+func PoolNames(fields elasticsearch.HitElement) []string {
+	prefix := "pools."
+	suffix := ".availabilityState.keyword"
+	names := make([]string, 0)
+	for f := range fields {
+		if !strings.HasPrefix(f, prefix) || !strings.HasSuffix(f, suffix) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(f, prefix), suffix)
+		if strings.Contains(name, ".members.") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The names of the members of a pool in the fields of a document, sorted
+// alphabetically
+func MemberNames(fields elasticsearch.HitElement, PoolName string) ([]string, error) {
+	r := "^pools\\." + regexp.QuoteMeta(PoolName) + "\\.members\\.(.*)\\.enabledState\\.keyword$"
+	re, err := regexp.Compile(r)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for f := range fields {
+		if match := re.FindStringSubmatch(f); match != nil {
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Collect the data of a single pool member. Only the availability and enabled
+// state are mandatory, all other fields are left empty if the telemetry data
+// doesn't contain them.
+func (p *Pool) getMember(fields elasticsearch.HitElement, member string) PoolMemberData {
+	var m PoolMemberData
+	prefix := "pools." + p.pool + ".members." + member + "."
+	m.AvailabilityState = fmt.Sprintf("%v", fields[prefix+"availabilityState.keyword"].([]interface{})[0])
+	m.EnabledState = fmt.Sprintf("%v", fields[prefix+"enabledState.keyword"].([]interface{})[0])
+	m.MonitorStatus = memberString(fields, prefix+"monitorStatus")
+	m.StatusReason = memberString(fields, prefix+"status.statusReason")
+	m.Address = memberString(fields, prefix+"addr")
+	m.Port = memberString(fields, prefix+"port")
+	if c, ok := memberFloat(fields, prefix+"curConns"); ok {
+		m.CurrentConnections = c
+	} else {
+		m.CurrentConnections, _ = memberFloat(fields, prefix+"serverside.curConns")
+	}
+	m.MaxConnections, _ = memberFloat(fields, prefix+"serverside.maxConns")
+	m.PacketsIn, _ = memberFloat(fields, prefix+"serverside.pktsIn")
+	m.PacketsOut, _ = memberFloat(fields, prefix+"serverside.pktsOut")
+	m.BitsIn, _ = memberFloat(fields, prefix+"serverside.bitsIn")
+	m.BitsOut, _ = memberFloat(fields, prefix+"serverside.bitsOut")
+	return m
+}
+
+// Get a string field, preferring the keyword variant. Returns an empty string
+// if the field doesn't exist.
+func memberString(fields elasticsearch.HitElement, fieldname string) string {
+	for _, f := range []string{fieldname + ".keyword", fieldname} {
+		if v, ok := fields[f].([]interface{}); ok && len(v) > 0 {
+			return fmt.Sprintf("%v", v[0])
+		}
+	}
+	return ""
+}
+
+// Get a numeric field, the second return value is false if it doesn't exist.
+func memberFloat(fields elasticsearch.HitElement, fieldname string) (float64, bool) {
+	if v, ok := fields[fieldname].([]interface{}); ok && len(v) > 0 {
+		if f, ok := v[0].(float64); ok {
+			return f, true
+		}
+	}
+	return 0, false
+}
+
+// Human readable description of the member state, e.g.
+// "/Common/web01:443 offline: Pool member has been marked down by a monitor"
+func (m PoolMemberData) describe(member string) string {
+	d := member + " " + m.AvailabilityState
+	if m.EnabledState != "enabled" {
+		d += " (" + m.EnabledState + ")"
+	}
+	if m.StatusReason != "" {
+		d += ": " + m.StatusReason
+	}
+	return d
+}
+
 func (p *Pool)getField(fields elasticsearch.HitElement, fieldname string)(float64,error) {
 	logger := log.With().Str("func", "gatherPoolState").Str("package", "pool").Str("pool", p.pool).Logger()
 	logger.Trace().Msg("Enter func")
@@ -229,53 +470,40 @@ func (p *Pool) Check(s *PoolState, Warn string, Crit string, AgeWarn string, Age
 	logger.Trace().Msg("Enter func")
 
 	ok := true
-	if checkRange(p.nagios, Crit, s.UnavailableMembers, "critical") {
+	if threshold.CheckRange(p.nagios, Crit, float64(s.UnavailableMembers), "critical") {
 		p.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v of %v pool members unavailable", s.UnavailableMembers, s.TotalMembers))
 		ok = false
 	}
-	if checkRange(p.nagios, Warn, s.UnavailableMembers, "warning") {
+	if threshold.CheckRange(p.nagios, Warn, float64(s.UnavailableMembers), "warning") {
 		p.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v of %v pool members unavailable", s.UnavailableMembers, s.TotalMembers))
 		ok = false
 	}
 	if ok {
-		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: pool %v is healthy, %v members available", p.pool, s.ActiveMemberCount))
+		p.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: pool %v is healthy, %v of %v members available", p.pool, s.TotalMembers-s.DownMemberCount, s.TotalMembers))
 	}
-	checkAddMemberResults(p.nagios, s.Members, p.ignore_disabled)
+	p.checkAddMemberResults(s.Members)
 	checkAddPerfdata(p.nagios, s)
-}
-
-func checkRange(nagios *nagiosplugin.Check, CheckRange string, Value uint, AlertType string) bool {
-	logger := log.With().Str("func", "checkRange").Str("package", "pool").Logger()
-	logger.Trace().Msg("Enter func")
-	if CheckRange == "" {
-		return false
+	if p.members.Perfdata {
+		checkAddMemberPerfdata(p.nagios, s.Members)
 	}
-	r, err := nagiosplugin.ParseRange(CheckRange)
-	if err != nil {
-		logger.Error().Str("id", "ERR10060001").
-			Str("field", AlertType).
-			Str("range", CheckRange).
-			Err(err).
-			Msg("Error parsing range")
-		nagios.AddResult(nagiosplugin.UNKNOWN, "error parsing "+AlertType+" range "+CheckRange)
-	}
-	return r.Check(float64(Value))
 }
 
-func checkAddMemberResults(nagios *nagiosplugin.Check, members PoolMemberState, ignore_disabled bool) {
-	for member, status := range members {
-		if ignore_disabled {
-			if status.AvailabilityState != "available" || status.EnabledState != "enabled" {
-				nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("Member %v: %v, %v", member, status.EnabledState, status.AvailabilityState))
-			} else {
-				nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("Member %v: %v, %v", member, status.EnabledState, status.AvailabilityState))
-			}
+// Add a result line per member, sorted by name
+func (p *Pool) checkAddMemberResults(members PoolMemberState) {
+	for _, member := range members.Names() {
+		status := members[member]
+		down := false
+		if p.ignore_disabled {
+			down = status.AvailabilityState != "available" || status.EnabledState != "enabled"
 		} else {
-			if status.EnabledState == "enabled" && status.AvailabilityState != "available" {
-				nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("Member %v: %v, %v", member, status.EnabledState, status.AvailabilityState))
-			} else {
-				nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("Member %v: %v, %v", member, status.EnabledState, status.AvailabilityState))
-			}
+			down = status.EnabledState == "enabled" && status.AvailabilityState != "available"
+		}
+		if down && status.Maintenance != "" {
+			p.nagios.AddResult(nagiosplugin.OK, status.describe(member)+" ("+status.Maintenance+")")
+		} else if down {
+			p.nagios.AddResult(p.members.Severity, status.describe(member))
+		} else if !p.members.ProblemsOnly {
+			p.nagios.AddResult(nagiosplugin.OK, status.describe(member))
 		}
 	}
 }
@@ -301,4 +529,19 @@ func checkAddPerfdata(nagios *nagiosplugin.Check, s *PoolState) {
 	nagios.AddPerfDatum("unavailable_member_count", "", p, nil, nil, nil, nil)
 	p, _ = nagiosplugin.NewFloatPerfDatumValue(float64(s.TotalMembers))
 	nagios.AddPerfDatum("total_members", "", p, nil, nil, nil, nil)
+	p, _ = nagiosplugin.NewFloatPerfDatumValue(float64(s.MaintenanceMembers))
+	nagios.AddPerfDatum("maintenance_member_count", "", p, nil, nil, nil, nil)
+}
+
+// add connections and traffic performance data for every member
+func checkAddMemberPerfdata(nagios *nagiosplugin.Check, members PoolMemberState) {
+	for _, member := range members.Names() {
+		m := members[member]
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(m.CurrentConnections)
+		nagios.AddPerfDatum(member+"_current_connections", "", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(m.BitsIn)
+		nagios.AddPerfDatum(member+"_bits_in", "c", p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(m.BitsOut)
+		nagios.AddPerfDatum(member+"_bits_out", "c", p, nil, nil, nil, nil)
+	}
 }
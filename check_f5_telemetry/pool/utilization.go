@@ -0,0 +1,50 @@
+package pool
+
+import (
+	"fmt"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Check the connection utilization of the pool in percent. The current
+// connections are compared to Limit, if it is greater than zero, otherwise to
+// the historical maximum from serverside.maxConns. Warn and Crit are ranges
+// for the utilization percentage.
+func (p *Pool) CheckUtilization(s *PoolState, Limit float64, Warn string, Crit string) {
+	logger := log.With().Str("func", "CheckUtilization").Str("package", "pool").Str("pool", p.pool).Logger()
+	logger.Trace().Msg("Enter func")
+
+	limit := Limit
+	source := "configured limit"
+	if limit <= 0 {
+		limit = s.MaxConnections
+		source = "historical maximum"
+	}
+	if limit <= 0 {
+		logger.Debug().Str("id", "DBG10080001").Msg("No connection limit to compare with")
+		p.nagios.AddResult(nagiosplugin.OK, "OK: no connection limit to calculate the utilization")
+		return
+	}
+	utilization := s.CurrentConnections / limit * 100
+	logger.Debug().Str("id", "DBG10080002").
+		Float64("current", s.CurrentConnections).
+		Float64("limit", limit).
+		Str("source", source).
+		Float64("utilization", utilization).
+		Msg("Utilization calculated")
+
+	msg := fmt.Sprintf("connection utilization %.1f%% (%v of %v, %v)", utilization, s.CurrentConnections, limit, source)
+	if threshold.CheckRange(p.nagios, Crit, utilization, "critical") {
+		p.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: "+msg)
+	} else if threshold.CheckRange(p.nagios, Warn, utilization, "warning") {
+		p.nagios.AddResult(nagiosplugin.WARNING, "WARNING: "+msg)
+	} else {
+		p.nagios.AddResult(nagiosplugin.OK, "OK: "+msg)
+	}
+
+	min := float64(0)
+	v, _ := nagiosplugin.NewFloatPerfDatumValue(utilization)
+	p.nagios.AddPerfDatum("connection_utilization", "%", v, threshold.PerfRange(Warn), threshold.PerfRange(Crit), &min, nil)
+}
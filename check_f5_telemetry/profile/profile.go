@@ -0,0 +1,304 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// A ratio in percent between the sum of the Numerator counters and the sum of
+// the Denominator counters
+type Ratio struct {
+	Name        string
+	Description string
+	Numerator   []string
+	Denominator []string
+}
+
+// The profile types with the section in the telemetry data and the ratios
+// calculated for them. The first ratio is the one the global warning and
+// critical ranges apply to.
+var ProfileTypes = map[string]struct {
+	Section string
+	Ratios  []Ratio
+}{
+	"http": {"httpProfiles", []Ratio{
+		{"5xx", "5xx responses", []string{"resp_5xxCnt"}, []string{"resp_2xxCnt", "resp_3xxCnt", "resp_4xxCnt", "resp_5xxCnt"}},
+		{"4xx", "4xx responses", []string{"resp_4xxCnt"}, []string{"resp_2xxCnt", "resp_3xxCnt", "resp_4xxCnt", "resp_5xxCnt"}},
+	}},
+	"tcp": {"tcpProfiles", []Ratio{
+		{"connfails", "failed connects", []string{"connfails"}, []string{"connects", "connfails"}},
+		{"abandons", "abandoned connections", []string{"abandons"}, []string{"accepts"}},
+	}},
+	"clientssl": {"clientSslProfiles", []Ratio{
+		{"handshake_failures", "SSL handshake failures", []string{"handshakeFailures"}, []string{"totNativeConns", "totCompatConns", "handshakeFailures"}},
+		{"fatal_alerts", "SSL fatal alerts", []string{"fatalAlerts"}, []string{"totNativeConns", "totCompatConns"}},
+		{"renegotiations", "SSL renegotiations", []string{"midstreamRenegotiations"}, []string{"totNativeConns", "totCompatConns"}},
+	}},
+	"serverssl": {"serverSslProfiles", []Ratio{
+		{"handshake_failures", "SSL handshake failures", []string{"handshakeFailures"}, []string{"totNativeConns", "totCompatConns", "handshakeFailures"}},
+		{"fatal_alerts", "SSL fatal alerts", []string{"fatalAlerts"}, []string{"totNativeConns", "totCompatConns"}},
+		{"renegotiations", "SSL renegotiations", []string{"midstreamRenegotiations"}, []string{"totNativeConns", "totCompatConns"}},
+	}},
+}
+
+// The Profile object created and initialized by NewProfile consolidates the
+// connection to Elasticsearch, the nagios object, the index name and the
+// profile needed to run the check.
+type Profile struct {
+	index        string
+	profile_type string
+	name         string
+	connection   *elasticsearch.Elasticsearch
+	nagios       *nagiosplugin.Check
+}
+
+// Counters of the profile in the latest sample and the calculated ratios. If
+// a previous sample exists and the counters haven't been reset, the ratios
+// are calculated from the difference between both samples, otherwise from the
+// absolute counters.
+type ProfileState struct {
+	Timestamp         time.Time
+	PreviousTimestamp time.Time
+	Counters          map[string]float64
+	Ratios            map[string]float64
+}
+
+// Creates a Profile object containing the connection object to Elasticsearch,
+// a Nagios object, the Index, the profile type (http, tcp, clientssl or
+// serverssl) and the full name of the profile.
+func NewProfile(Index string, ProfileType string, Name string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Profile, error) {
+	var p *Profile
+
+	logger := log.With().Str("func", "NewProfile").Str("package", "profile").Logger()
+	logger.Trace().Msg("Enter func")
+	if _, found := ProfileTypes[ProfileType]; !found {
+		logger.Error().Str("id", "ERR70010001").Str("type", ProfileType).Msg("Illegal profile type")
+		return nil, errors.New("Illegal profile type " + ProfileType + ", use one of " + strings.Join(Types(), ", "))
+	}
+	p = new(Profile)
+	p.index = Index
+	p.profile_type = ProfileType
+	p.name = Name
+	p.connection = Connection
+	p.nagios = Nagios
+	return p, nil
+}
+
+// The known profile types, sorted alphabetically
+func Types() []string {
+	types := make([]string, 0, len(ProfileTypes))
+	for t := range ProfileTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Execute the query, fetching the two latest samples
+func (p *Profile) Execute() (*ProfileState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "profile").Logger()
+	logger.Trace().Msg("Enter func")
+	q := "{\"size\":2,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + p.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"" + ProfileTypes[p.profile_type].Section + "." + p.name + ".*\"],\"_source\":false}"
+	data, err := p.connection.Search(p.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR70020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, p.index, q))
+		return nil, err
+	}
+	return p.gatherProfileState(data)
+}
+
+// Convert the two latest samples of the profile, see Execute
+func (p *Profile) Gather(e *elasticsearch.ElasticsearchResult) (*ProfileState, error) {
+	return p.gatherProfileState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (p *Profile) gatherProfileState(e *elasticsearch.ElasticsearchResult) (*ProfileState, error) {
+	logger := log.With().Str("func", "gatherProfileState").Str("package", "profile").Str("profile", p.name).Logger()
+	logger.Trace().Msg("Enter func")
+
+	s := new(ProfileState)
+	var previous map[string]float64
+	for i, hit := range e.Hits.Hits {
+		counters, ts, err := p.parseSample(hit.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			s.Timestamp = ts
+			s.Counters = counters
+		} else {
+			s.PreviousTimestamp = ts
+			previous = counters
+		}
+	}
+	if len(s.Counters) == 0 {
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("No data for %v profile %v. Does this profile exist?", p.profile_type, p.name))
+		logger.Error().Str("id", "ERR70030001").Msg("No data for profile")
+		return nil, errors.New("No data for profile " + p.name)
+	}
+	for c, v := range previous {
+		if s.Counters[c] < v {
+			logger.Info().Str("id", "INF70030001").Str("counter", c).Msg("Counters have been reset, using absolute values")
+			previous = nil
+			break
+		}
+	}
+	if len(previous) == 0 {
+		s.PreviousTimestamp = time.Time{}
+	}
+
+	s.Ratios = make(map[string]float64)
+	for _, r := range ProfileTypes[p.profile_type].Ratios {
+		numerator := s.sum(r.Numerator, previous)
+		denominator := s.sum(r.Denominator, previous)
+		if denominator > 0 {
+			s.Ratios[r.Name] = numerator / denominator * 100
+		} else {
+			s.Ratios[r.Name] = 0
+		}
+		logger.Debug().Str("id", "DBG70030001").
+			Str("ratio", r.Name).
+			Float64("numerator", numerator).
+			Float64("denominator", denominator).
+			Float64("value", s.Ratios[r.Name]).
+			Msg("Ratio calculated")
+	}
+	return s, nil
+}
+
+// Sum up the counters, subtracting the previous values if given
+func (s *ProfileState) sum(Counters []string, Previous map[string]float64) float64 {
+	var sum float64
+	for _, c := range Counters {
+		sum += s.Counters[c] - Previous[c]
+	}
+	return sum
+}
+
+// Parse the counters of the profile from a single sample
+func (p *Profile) parseSample(fields elasticsearch.HitElement) (map[string]float64, time.Time, error) {
+	logger := log.With().Str("func", "parseSample").Str("package", "profile").Logger()
+	logger.Trace().Msg("Enter func")
+
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		p.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR70040001").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, ts, err
+	}
+	counters := make(map[string]float64)
+	prefix := ProfileTypes[p.profile_type].Section + "." + p.name + "."
+	for field, v := range fields {
+		if !strings.HasPrefix(field, prefix) {
+			continue
+		}
+		if l, ok := v.([]interface{}); ok && len(l) > 0 {
+			if n, ok := l[0].(float64); ok {
+				counters[strings.TrimPrefix(field, prefix)] = n
+			}
+		}
+	}
+	return counters, ts, nil
+}
+
+// Returns a resolver for the ratio names of the profile type
+func Resolver(ProfileType string) threshold.Resolver {
+	return func(Name string) (string, bool) {
+		for _, r := range ProfileTypes[ProfileType].Ratios {
+			if strings.EqualFold(r.Name, Name) {
+				return r.Name, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to the first ratio of the profile type.
+func MergeThresholds(ProfileType string, Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string) (threshold.Thresholds, error) {
+	t, found := ProfileTypes[ProfileType]
+	if !found {
+		return nil, errors.New("Illegal profile type " + ProfileType + ", use one of " + strings.Join(Types(), ", "))
+	}
+	return threshold.Merge(Warn, Crit, []string{t.Ratios[0].Name}, Config, Definitions, Resolver(ProfileType))
+}
+
+// Check the ratios against the thresholds
+func (p *Profile) Check(s *ProfileState, Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "profile").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	since := "since the statistics were reset"
+	if !s.PreviousTimestamp.IsZero() {
+		since = "in the last " + s.Timestamp.Sub(s.PreviousTimestamp).String()
+	}
+	summary := make([]string, 0)
+	for _, r := range ProfileTypes[p.profile_type].Ratios {
+		v := s.Ratios[r.Name]
+		th := Thresholds[r.Name]
+		msg := fmt.Sprintf("%.2f%% %v %v", v, r.Description, since)
+		if threshold.CheckRange(p.nagios, th.Critical, v, "critical") {
+			p.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: "+p.name+" "+msg)
+			ok = false
+		} else if threshold.CheckRange(p.nagios, th.Warning, v, "warning") {
+			p.nagios.AddResult(nagiosplugin.WARNING, "WARNING: "+p.name+" "+msg)
+			ok = false
+		}
+		summary = append(summary, msg)
+	}
+	if ok {
+		p.nagios.AddResult(nagiosplugin.OK, "OK: "+p.name+" "+strings.Join(summary, ", "))
+	}
+	p.checkAddPerfdata(s, Thresholds)
+}
+
+// add the ratios and the counters used to calculate them to the performance
+// data
+func (p *Profile) checkAddPerfdata(s *ProfileState, Thresholds threshold.Thresholds) {
+	min := float64(0)
+	max := float64(100)
+	counters := make(map[string]bool)
+	for _, r := range ProfileTypes[p.profile_type].Ratios {
+		th := Thresholds[r.Name]
+		v, _ := nagiosplugin.NewFloatPerfDatumValue(s.Ratios[r.Name])
+		p.nagios.AddPerfDatum(r.Name+"_ratio", "%", v, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), &min, &max)
+		for _, c := range append(r.Numerator, r.Denominator...) {
+			counters[c] = true
+		}
+	}
+	names := make([]string, 0, len(counters))
+	for c := range counters {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	for _, c := range names {
+		v, _ := nagiosplugin.NewFloatPerfDatumValue(s.Counters[c])
+		p.nagios.AddPerfDatum(c, "c", v, nil, nil, nil, nil)
+	}
+}
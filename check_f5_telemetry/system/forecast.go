@@ -0,0 +1,55 @@
+package system
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/forecast"
+)
+
+// The metrics forecasted if none are given
+var DefaultForecastMetrics = []string{"memory", "tmmMemory"}
+
+// The limit of the forecast if none is given. All metrics are percentages,
+// so their limit is 100 percent.
+const DefaultForecastLimit = "100"
+
+// The usage of these resources is stored in percent
+var percentFields = [...]string{
+	"memory",
+	"tmmMemory",
+	"swap"}
+
+// Map the metric names to their fields for a forecast, see
+// forecast.Forecast. Besides the names in percentFields, "disk:<mount>" uses
+// the capacity of a file system, e.g. "disk:/var".
+func ForecastMetrics(Names []string) (map[string]forecast.Metric, error) {
+	if len(Names) == 0 {
+		Names = DefaultForecastMetrics
+	}
+	result := make(map[string]forecast.Metric)
+	for _, n := range Names {
+		name := strings.TrimSpace(n)
+		if strings.HasPrefix(name, "disk:") && len(name) > len("disk:") {
+			result[name] = forecast.Metric{
+				Field:    "system.diskStorage." + strings.TrimPrefix(name, "disk:") + ".Capacity_Float",
+				Scale:    100,
+				Unit:     "%",
+				PerfUnit: "%",
+			}
+			continue
+		}
+		found := false
+		for _, f := range percentFields {
+			if strings.EqualFold(f, name) {
+				result[f] = forecast.Metric{Field: "system." + f, Scale: 1, Unit: "%", PerfUnit: "%"}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.New("Unknown forecast metric " + name + ", use memory, tmmMemory, swap or disk:<mount>")
+		}
+	}
+	return result, nil
+}
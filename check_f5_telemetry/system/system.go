@@ -0,0 +1,262 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The provisioning level of a module is stored in these fields
+var provisioningFields = regexp.MustCompile("^system\\.provisioning\\.([^.]+)\\.level$")
+
+// The System object created and initialized by NewSystem consolidates the
+// connection to Elasticsearch, the nagios object, the index name and the
+// expected provisioning and versions needed to run the check.
+type System struct {
+	index        string
+	provisioning []string
+	versions     []string
+	connection   *elasticsearch.Elasticsearch
+	nagios       *nagiosplugin.Check
+}
+
+// License, provisioning and version of the device. Provisioning maps the
+// module names to their provisioning level.
+type SystemState struct {
+	Timestamp      time.Time
+	LicenseReady   bool
+	ProvisionReady bool
+	Provisioning   map[string]string
+	Version        string
+}
+
+// The modules with a provisioning level other than "none", sorted
+// alphabetically
+func (s *SystemState) Provisioned() []string {
+	modules := make([]string, 0, len(s.Provisioning))
+	for module, level := range s.Provisioning {
+		if level != "none" {
+			modules = append(modules, module)
+		}
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// Creates a System object containing the connection object to Elasticsearch,
+// a Nagios object, the Index, the modules expected to be provisioned and the
+// allowed TMOS versions. Empty lists disable the respective check.
+func NewSystem(Index string, Provisioning []string, Versions []string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*System, error) {
+	var s *System
+
+	logger := log.With().Str("func", "NewSystem").Str("package", "system").Logger()
+	logger.Trace().Msg("Enter func")
+	s = new(System)
+	s.index = Index
+	s.provisioning = cleanList(Provisioning)
+	s.versions = cleanList(Versions)
+	s.connection = Connection
+	s.nagios = Nagios
+	return s, nil
+}
+
+// Split the entries of a list at commas (the configuration file may contain
+// a single string like "ltm,asm") and remove blanks and empty entries
+func cleanList(List []string) []string {
+	result := make([]string, 0, len(List))
+	for _, entry := range List {
+		for _, e := range strings.Split(entry, ",") {
+			e = strings.ToLower(strings.TrimSpace(e))
+			if e != "" {
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+// Execute the query and return the state of the device
+func (s *System) Execute() (*SystemState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "system").Logger()
+	logger.Trace().Msg("Enter func")
+	q := "{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + s.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"system.licenseReady\",\"system.provisionReady\",\"system.provisioning.*\",\"system.version\"],\"_source\":false}"
+	data, err := s.connection.Search(s.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR90020001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		s.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, s.index, q))
+		return nil, err
+	}
+	return s.gatherSystemState(data)
+}
+
+// Convert the latest document with the system state, see Execute
+func (s *System) Gather(e *elasticsearch.ElasticsearchResult) (*SystemState, error) {
+	return s.gatherSystemState(e)
+}
+
+// Convert the Elasticsearch data into our data structure
+func (s *System) gatherSystemState(e *elasticsearch.ElasticsearchResult) (*SystemState, error) {
+	var fields elasticsearch.HitElement
+	logger := log.With().Str("func", "gatherSystemState").Str("package", "system").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(e.Hits.Hits) > 0 {
+		fields = e.Hits.Hits[0].Fields
+	}
+	if fields["@timestamp"] == nil || fields["system.licenseReady"] == nil {
+		s.nagios.AddResult(nagiosplugin.UNKNOWN, "No data for system status check")
+		logger.Error().Str("id", "ERR90030001").Msg("No data for system status check")
+		return nil, errors.New("No data for system status check")
+	}
+
+	state := new(SystemState)
+	f := "2006-01-02T15:04:05.000Z"
+	t := fmt.Sprintf("%v", fields["@timestamp"].([]interface{})[0])
+	ts, err := time.Parse(f, t)
+	if err != nil {
+		s.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not parse @timestamp %v. ", t))
+		logger.Error().Str("id", "ERR90030002").
+			Str("field", "@timestamp").
+			Str("value", t).
+			Str("format", f).
+			Err(err).
+			Msg("Could not parse timestamp")
+		return nil, err
+	}
+	state.Timestamp = ts
+	state.LicenseReady = fieldBool(fields, "system.licenseReady")
+	state.ProvisionReady = fieldBool(fields, "system.provisionReady")
+	state.Version = fieldString(fields, "system.version")
+	state.Provisioning = make(map[string]string)
+	for field := range fields {
+		match := provisioningFields.FindStringSubmatch(field)
+		if match == nil {
+			continue
+		}
+		state.Provisioning[strings.ToLower(match[1])] = fieldString(fields, field)
+	}
+	logger.Debug().Str("id", "DBG90030001").
+		Bool("license_ready", state.LicenseReady).
+		Bool("provision_ready", state.ProvisionReady).
+		Strs("provisioned", state.Provisioned()).
+		Str("version", state.Version).
+		Msg("System state")
+	return state, nil
+}
+
+// Read a field as string, returns an empty string if it is missing
+func fieldString(fields elasticsearch.HitElement, Field string) string {
+	l, ok := fields[Field].([]interface{})
+	if !ok || len(l) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v", l[0])
+}
+
+// Read a field as boolean, the telemetry data may store it as a string
+func fieldBool(fields elasticsearch.HitElement, Field string) bool {
+	return strings.EqualFold(fieldString(fields, Field), "true")
+}
+
+// Check whether a version is allowed. An allowed version matches the TMOS
+// version exactly or is a prefix ending at a dot, e.g. "15.1" allows
+// "15.1.0.4".
+func versionAllowed(Version string, Allowed []string) bool {
+	for _, a := range Allowed {
+		if Version == a || strings.HasPrefix(Version, strings.TrimSuffix(a, ".")+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the license, provisioning and the TMOS version
+func (s *System) Check(state *SystemState, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "system").Logger()
+	logger.Trace().Msg("Enter func")
+
+	ok := true
+	if !state.LicenseReady {
+		s.nagios.AddResult(nagiosplugin.CRITICAL, "CRITICAL: License is not ready")
+		ok = false
+	}
+	if !state.ProvisionReady {
+		s.nagios.AddResult(nagiosplugin.WARNING, "WARNING: Provisioning is not ready")
+		ok = false
+	}
+
+	provisioned := state.Provisioned()
+	if len(s.provisioning) > 0 {
+		missing := difference(s.provisioning, provisioned)
+		unexpected := difference(provisioned, s.provisioning)
+		if len(missing) > 0 {
+			s.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: Expected modules not provisioned: %v", strings.Join(missing, ",")))
+			ok = false
+		}
+		if len(unexpected) > 0 {
+			s.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: Unexpected modules provisioned: %v", strings.Join(unexpected, ",")))
+			ok = false
+		}
+	}
+
+	if len(s.versions) > 0 && !versionAllowed(state.Version, s.versions) {
+		s.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: TMOS version %v is not in the allowed versions %v", state.Version, strings.Join(s.versions, ",")))
+		ok = false
+	}
+
+	if ok {
+		s.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: License ready, TMOS %v, provisioned modules %v", state.Version, strings.Join(provisioned, ",")))
+	}
+	s.checkAddPerfdata(state)
+}
+
+// The elements of a which are missing in b
+func difference(a []string, b []string) []string {
+	result := []string{}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+// add the readiness flags to the performance data
+func (s *System) checkAddPerfdata(state *SystemState) {
+	min := 0.0
+	max := 1.0
+	p, _ := nagiosplugin.NewFloatPerfDatumValue(boolValue(state.LicenseReady))
+	s.nagios.AddPerfDatum("license_ready", "", p, nil, nil, &min, &max)
+	p, _ = nagiosplugin.NewFloatPerfDatumValue(boolValue(state.ProvisionReady))
+	s.nagios.AddPerfDatum("provision_ready", "", p, nil, nil, &min, &max)
+}
+
+// 1 for true, 0 for false
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,398 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// The metrics calculated for every device. Coverage is the percentage of the
+// expected documents received in the window, gap the longest time in seconds
+// without a document and lag the difference in seconds between @timestamp
+// and system.systemTimestamp of the latest document.
+var MetricFields = [...]string{
+	"coverage",
+	"gap",
+	"lag"}
+
+// Units of the metrics for the performance data
+var MetricUnits = map[string]string{
+	"coverage": "%",
+	"gap":      "s",
+	"lag":      "s",
+}
+
+// The Telemetry object created and initialized by NewTelemetry consolidates
+// the connection to Elasticsearch, the nagios object, the index name, the
+// window and poll interval needed to run the check and the devices expected
+// to send data.
+type Telemetry struct {
+	index       string
+	window      time.Duration
+	interval    time.Duration
+	lookback    time.Duration
+	deviceField string
+	expected    []string
+	connection  *elasticsearch.Elasticsearch
+	nagios      *nagiosplugin.Check
+}
+
+// Statistics of the documents sent by a device
+type DeviceData struct {
+	Documents float64
+	Expected  float64
+	Last      time.Time
+	Fields    map[string]float64
+}
+
+// Statistics of all devices which sent documents in the window. Missing
+// contains the expected devices which sent no documents in the window with
+// the time of their latest document in the lookback (zero if there is none).
+// Untimed is the number of documents without @timestamp, which are invisible
+// to all time based queries.
+type TelemetryState struct {
+	Timestamp time.Time
+	Devices   map[string]DeviceData
+	Missing   map[string]time.Time
+	Untimed   float64
+}
+
+// The names of the missing devices, sorted alphabetically
+func (s *TelemetryState) MissingNames() []string {
+	names := make([]string, 0, len(s.Missing))
+	for name := range s.Missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The device names, sorted alphabetically
+func (s *TelemetryState) Names() []string {
+	names := make([]string, 0, len(s.Devices))
+	for name := range s.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates a Telemetry object containing the connection object to
+// Elasticsearch, a Nagios object, the Index, the time Window to check, the
+// expected poll Interval of the telemetry and the field identifying the
+// device. Every device which sent data within the Lookback (at least the
+// Window) or is listed in Expected must have sent data within the Window.
+func NewTelemetry(Index string, Window time.Duration, Interval time.Duration, Lookback time.Duration, DeviceField string, Expected []string, Connection *elasticsearch.Elasticsearch, Nagios *nagiosplugin.Check) (*Telemetry, error) {
+	var t *Telemetry
+
+	logger := log.With().Str("func", "NewTelemetry").Str("package", "telemetry").Logger()
+	logger.Trace().Msg("Enter func")
+	if Interval <= 0 || Window < Interval {
+		logger.Error().Str("id", "ERR10001001").
+			Str("window", Window.String()).
+			Str("interval", Interval.String()).
+			Msg("Invalid window or interval")
+		return nil, errors.New("The window must be at least as long as the poll interval")
+	}
+	t = new(Telemetry)
+	t.index = Index
+	t.window = Window
+	t.interval = Interval
+	t.lookback = Lookback
+	if t.lookback < Window {
+		t.lookback = Window
+	}
+	t.deviceField = DeviceField
+	t.expected = Expected
+	t.connection = Connection
+	t.nagios = Nagios
+	return t, nil
+}
+
+// Execute the aggregation query and calculate the statistics per device
+func (t *Telemetry) Execute() (*TelemetryState, error) {
+	logger := log.With().Str("func", "Execute").Str("package", "telemetry").Logger()
+	logger.Trace().Msg("Enter func")
+	// The devices are collected over the lookback, so a device which stopped
+	// sending still has a bucket, and only the documents of the window are
+	// used for the statistics. Documents without @timestamp are counted
+	// separately as no range query can find them.
+	field, _ := json.Marshal(t.deviceField)
+	untimed := "{\"bool\":{\"must_not\":[{\"exists\":{\"field\":\"@timestamp\"}}]}}"
+	query := fmt.Sprintf("{\"bool\":{\"should\":[{\"range\":{\"@timestamp\":{\"gte\":\"now-%ds\"}}},%v],\"minimum_should_match\":1}}",
+		int64(t.lookback.Seconds()), untimed)
+	q := fmt.Sprintf("{\"size\":0,\"query\":%v,\"aggs\":{\"untimed\":{\"filter\":%v},"+
+		"\"devices\":{\"terms\":{\"field\":%s,\"size\":1000},\"aggs\":{"+
+		"\"last\":{\"max\":{\"field\":\"@timestamp\"}},"+
+		"\"window\":{\"filter\":{\"range\":{\"@timestamp\":{\"gte\":\"now-%ds\"}}},\"aggs\":{"+
+		"\"timeline\":{\"date_histogram\":{\"field\":\"@timestamp\",\"fixed_interval\":\"%ds\",\"min_doc_count\":1}},"+
+		"\"latest\":{\"top_hits\":{\"size\":1,\"sort\":[{\"@timestamp\":{\"order\":\"desc\"}}],\"_source\":false,\"fields\":[\"@timestamp\",\"system.systemTimestamp\"]}}}}}}}}",
+		t.connection.Filter(query), untimed, field, int64(t.window.Seconds()), int64(t.interval.Seconds()))
+	now := time.Now()
+	data, err := t.connection.Search(t.index, q)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR10002001").
+			Str("parsed_query", q).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		t.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, t.index, q))
+		return nil, err
+	}
+	return t.gatherTelemetryState(data, now)
+}
+
+// Convert the aggregations into our data structure
+func (t *Telemetry) gatherTelemetryState(e *elasticsearch.ElasticsearchResult, Now time.Time) (*TelemetryState, error) {
+	logger := log.With().Str("func", "gatherTelemetryState").Str("package", "telemetry").Logger()
+	logger.Trace().Msg("Enter func")
+
+	s := new(TelemetryState)
+	s.Timestamp = Now
+	s.Devices = make(map[string]DeviceData)
+	s.Missing = make(map[string]time.Time)
+	s.Untimed, _ = e.Aggregations["untimed"]["doc_count"].(float64)
+	buckets, _ := e.Aggregations["devices"]["buckets"].([]interface{})
+	for _, b := range buckets {
+		bucket, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("%v", bucket["key"])
+		window, _ := bucket["window"].(map[string]interface{})
+		if count, _ := window["doc_count"].(float64); count == 0 {
+			var last time.Time
+			if l, ok := bucket["last"].(map[string]interface{}); ok {
+				if ms, ok := l["value"].(float64); ok {
+					last = time.UnixMilli(int64(ms)).UTC()
+				}
+			}
+			logger.Debug().Str("id", "DBG10003001").Str("device", name).Time("last", last).Msg("No documents in the window")
+			s.Missing[name] = last
+			continue
+		}
+		d, err := t.gatherDevice(window, Now)
+		if err != nil {
+			t.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("Could not read the telemetry data of %v: %v", name, err))
+			logger.Error().Str("id", "ERR10003001").Str("device", name).Err(err).Msg("Could not read the telemetry data")
+			return nil, err
+		}
+		s.Devices[name] = d
+	}
+	for _, name := range t.expected {
+		_, found := s.Devices[name]
+		if _, missing := s.Missing[name]; !found && !missing {
+			logger.Debug().Str("id", "DBG10003002").Str("device", name).Msg("Expected device has no documents in the lookback")
+			s.Missing[name] = time.Time{}
+		}
+	}
+	return s, nil
+}
+
+// Calculate the statistics of a single device from its bucket
+func (t *Telemetry) gatherDevice(Bucket map[string]interface{}, Now time.Time) (DeviceData, error) {
+	logger := log.With().Str("func", "gatherDevice").Str("package", "telemetry").Logger()
+	logger.Trace().Msg("Enter func")
+
+	d := DeviceData{Fields: make(map[string]float64)}
+	d.Documents, _ = Bucket["doc_count"].(float64)
+	d.Expected = math.Floor(t.window.Seconds() / t.interval.Seconds())
+	d.Fields["coverage"] = math.Round(1000*d.Documents/d.Expected) / 10
+
+	// The longest gap is the longest time between two consecutive non-empty
+	// buckets, between the start of the window and the first bucket or
+	// between the latest document and now.
+	timeline, _ := Bucket["timeline"].(map[string]interface{})
+	keys := []float64{}
+	if list, ok := timeline["buckets"].([]interface{}); ok {
+		for _, b := range list {
+			tb, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key, _ := tb["key"].(float64)
+			count, _ := tb["doc_count"].(float64)
+			if count > 0 {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Float64s(keys)
+
+	latest, _ := Bucket["latest"].(map[string]interface{})
+	result, _ := latest["hits"].(map[string]interface{})
+	hits, _ := result["hits"].([]interface{})
+	if len(hits) == 0 || len(keys) == 0 {
+		return d, errors.New("no documents in the aggregation")
+	}
+	hit, _ := hits[0].(map[string]interface{})
+	fields, _ := hit["fields"].(map[string]interface{})
+	last, err := parseTimestamp(fieldString(fields, "@timestamp"))
+	if err != nil {
+		return d, err
+	}
+	d.Last = last
+
+	start := float64(Now.Add(-t.window).UnixMilli())
+	gap := math.Max(0, (keys[0]-start)/1000)
+	for i := 1; i < len(keys); i++ {
+		gap = math.Max(gap, (keys[i]-keys[i-1])/1000-t.interval.Seconds())
+	}
+	gap = math.Max(gap, Now.Sub(last).Seconds())
+	d.Fields["gap"] = math.Round(gap)
+
+	if v := fieldString(fields, "system.systemTimestamp"); v != "" {
+		system, err := parseTimestamp(v)
+		if err != nil {
+			return d, err
+		}
+		d.Fields["lag"] = math.Abs(last.Sub(system).Seconds())
+	} else {
+		logger.Warn().Str("id", "WRN10004001").Msg("system.systemTimestamp is missing")
+	}
+	logger.Debug().Str("id", "DBG10004001").
+		Float64("documents", d.Documents).
+		Float64("expected", d.Expected).
+		Time("last", d.Last).
+		Interface("fields", d.Fields).
+		Msg("Device statistics")
+	return d, nil
+}
+
+// Read a field from the fields of a hit, returns an empty string if it is
+// missing
+func fieldString(fields map[string]interface{}, Field string) string {
+	l, ok := fields[Field].([]interface{})
+	if !ok || len(l) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v", l[0])
+}
+
+// Parse a timestamp in the format of @timestamp, falling back to RFC3339 as
+// used by system.systemTimestamp
+func parseTimestamp(Value string) (time.Time, error) {
+	ts, err := time.Parse("2006-01-02T15:04:05.000Z", Value)
+	if err != nil {
+		ts, err = time.Parse(time.RFC3339, Value)
+	}
+	return ts, err
+}
+
+// Find the metric in MetricFields, ignoring the case as viper converts all
+// keys in the configuration file to lower case
+func MetricName(Name string) (string, bool) {
+	for _, f := range MetricFields {
+		if strings.EqualFold(f, Name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to the coverage. Unless it has its own
+// thresholds, a gap longer than two poll intervals raises a warning.
+func MergeThresholds(Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string, Interval time.Duration) (threshold.Thresholds, error) {
+	thresholds, err := threshold.Merge(Warn, Crit, []string{"coverage"}, Config, Definitions, MetricName)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := thresholds["gap"]; !found {
+		thresholds["gap"] = threshold.Threshold{Warning: fmt.Sprintf("%d", int64(2*Interval.Seconds()))}
+	}
+	return thresholds, nil
+}
+
+// Check whether any device stopped sending or any metric reached its
+// thresholds
+func (t *Telemetry) Check(s *TelemetryState, Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
+	logger := log.With().Str("func", "Check").Str("package", "telemetry").Logger()
+	logger.Trace().Msg("Enter func")
+
+	if len(s.Devices) == 0 && len(s.Missing) == 0 {
+		t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: No telemetry data received in the last %v", t.window))
+		return
+	}
+	ok := true
+	for _, name := range s.MissingNames() {
+		last := s.Missing[name]
+		if last.IsZero() {
+			t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v sent no telemetry data in the last %v", name, t.lookback))
+		} else {
+			t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v sent no telemetry data in the last %v, the latest document is from %v",
+				name, t.window, last.Local().Format("2006-01-02 15:04:05")))
+		}
+		ok = false
+	}
+	if s.Untimed > 0 {
+		t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v documents without @timestamp in index %v", s.Untimed, t.index))
+		ok = false
+	}
+	for _, name := range s.Names() {
+		d := s.Devices[name]
+		for _, f := range MetricFields {
+			th, found := Thresholds[f]
+			if !found {
+				continue
+			}
+			v, found := d.Fields[f]
+			if !found {
+				continue
+			}
+			logger.Debug().Str("id", "DBG10005001").
+				Str("device", name).
+				Str("field", f).
+				Float64("value", v).
+				Msg("Check threshold")
+			if threshold.CheckRange(t.nagios, th.Critical, v, "critical") {
+				t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v %v %v%v is outside the critical range %v", name, f, v, MetricUnits[f], th.Critical))
+				ok = false
+			} else if threshold.CheckRange(t.nagios, th.Warning, v, "warning") {
+				t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v %v %v%v is outside the warning range %v", name, f, v, MetricUnits[f], th.Warning))
+				ok = false
+			}
+		}
+	}
+	if ok {
+		t.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: %v devices sent telemetry data in the last %v", len(s.Devices), t.window))
+	}
+	t.checkAddPerfdata(s, Thresholds)
+}
+
+// add the documents and metrics of every device to the performance data
+func (t *Telemetry) checkAddPerfdata(s *TelemetryState, Thresholds threshold.Thresholds) {
+	p, _ := nagiosplugin.NewFloatPerfDatumValue(s.Untimed)
+	t.nagios.AddPerfDatum("untimed_documents", "", p, nil, nil, nil, nil)
+	for _, name := range s.MissingNames() {
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(0)
+		t.nagios.AddPerfDatum(name+"_documents", "", p, nil, nil, nil, nil)
+	}
+	for _, name := range s.Names() {
+		d := s.Devices[name]
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(d.Documents)
+		t.nagios.AddPerfDatum(name+"_documents", "", p, nil, nil, nil, nil)
+		for _, f := range MetricFields {
+			v, found := d.Fields[f]
+			if !found {
+				continue
+			}
+			th := Thresholds[f]
+			p, _ := nagiosplugin.NewFloatPerfDatumValue(v)
+			t.nagios.AddPerfDatum(name+"_"+f, MetricUnits[f], p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// package threshold handles warning and critical ranges for named metrics
+package threshold
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Warning and critical range for a single metric. Empty ranges are not
+// evaluated.
+type Threshold struct {
+	Warning  string `yaml:"warning" json:"warning" mapstructure:"warning"`
+	Critical string `yaml:"critical" json:"critical" mapstructure:"critical"`
+}
+
+// Thresholds per metric name
+type Thresholds map[string]Threshold
+
+// Maps a metric name to the name used by the check. The second return value
+// is false for unknown metrics.
+type Resolver func(Name string) (string, bool)
+
+// Parse a threshold definition in the form "metric=warning,critical". Either
+// range may be left empty, e.g. "outBits=,900M" only sets a critical range.
+// The ranges use the nagios range format, numbers may have SI suffixes.
+func Parse(Definition string) (string, Threshold, error) {
+	var th Threshold
+
+	kv := strings.SplitN(Definition, "=", 2)
+	if len(kv) != 2 {
+		return "", th, errors.New("Threshold " + Definition + " is not in the form metric=warning,critical")
+	}
+	ranges := strings.SplitN(kv[1], ",", 2)
+	th.Warning = strings.TrimSpace(ranges[0])
+	if len(ranges) > 1 {
+		th.Critical = strings.TrimSpace(ranges[1])
+	}
+	th, err := th.Expand()
+	if err != nil {
+		return "", th, err
+	}
+	return strings.TrimSpace(kv[0]), th, nil
+}
+
+// Expand the SI suffixes in both ranges and check, if they can be parsed
+func (th Threshold) Expand() (Threshold, error) {
+	var expanded Threshold
+	var err error
+
+	if expanded.Warning, err = expandRange(th.Warning); err != nil {
+		return th, err
+	}
+	if expanded.Critical, err = expandRange(th.Critical); err != nil {
+		return th, err
+	}
+	return expanded, nil
+}
+
+// Expand a single range and check, if it can be parsed
+func expandRange(CheckRange string) (string, error) {
+	if CheckRange == "" {
+		return "", nil
+	}
+	r, err := units.ExpandRange(CheckRange)
+	if err != nil {
+		return "", errors.New("Invalid range " + CheckRange + ": " + err.Error())
+	}
+	if _, err := nagiosplugin.ParseRange(r); err != nil {
+		return "", errors.New("Invalid range " + CheckRange + ": " + err.Error())
+	}
+	return r, nil
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to the Defaults metrics, unless they have
+// their own thresholds. Thresholds from the command line override those from
+// the configuration file. Resolve maps the names to the metric names known by
+// the check.
+func Merge(Warn string, Crit string, Defaults []string, Config map[string]Threshold, Definitions []string, Resolve Resolver) (Thresholds, error) {
+	thresholds := make(Thresholds)
+	if Warn != "" || Crit != "" {
+		th, err := Threshold{Warn, Crit}.Expand()
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range Defaults {
+			thresholds[metric] = th
+		}
+	}
+	for name, th := range Config {
+		metric, ok := Resolve(name)
+		if !ok {
+			return nil, errors.New("Unknown metric " + name + " in thresholds")
+		}
+		th, err := th.Expand()
+		if err != nil {
+			return nil, err
+		}
+		thresholds[metric] = th
+	}
+	for _, d := range Definitions {
+		name, th, err := Parse(d)
+		if err != nil {
+			return nil, err
+		}
+		metric, ok := Resolve(name)
+		if !ok {
+			return nil, errors.New("Unknown metric " + name + " in threshold " + d)
+		}
+		thresholds[metric] = th
+	}
+	return thresholds, nil
+}
+
+// Parse a range for the performance data, invalid or empty ranges are omitted
+func PerfRange(CheckRange string) *nagiosplugin.Range {
+	if CheckRange == "" {
+		return nil
+	}
+	r, err := nagiosplugin.ParseRange(CheckRange)
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// Check, if the Value is inside the nagios range CheckRange, i.e. whether the
+// threshold has been reached. An empty range is never reached. If the range
+// can't be parsed, an unknown result naming the AlertType is added.
+func CheckRange(Nagios *nagiosplugin.Check, CheckRange string, Value float64, AlertType string) bool {
+	logger := log.With().Str("func", "CheckRange").Str("package", "threshold").Logger()
+	logger.Trace().Msg("Enter func")
+	if CheckRange == "" {
+		return false
+	}
+	r, err := nagiosplugin.ParseRange(CheckRange)
+	if err != nil {
+		logger.Error().Str("id", "ERR14001001").
+			Str("field", AlertType).
+			Str("range", CheckRange).
+			Err(err).
+			Msg("Error parsing range")
+		Nagios.AddResult(nagiosplugin.UNKNOWN, "error parsing "+AlertType+" range "+CheckRange)
+		return false
+	}
+	return r.Check(Value)
+}
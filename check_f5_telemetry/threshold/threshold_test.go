@@ -0,0 +1,35 @@
+package threshold
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		definition string
+		wantName   string
+		want       Threshold
+		wantErr    bool
+	}{
+		{"inBits=800M,950M", "inBits", Threshold{Warning: "800000000", Critical: "950000000"}, false},
+		{"outBits=,900M", "outBits", Threshold{Critical: "900000000"}, false},
+		{"gap=120", "gap", Threshold{Warning: "120"}, false},
+		{" coverage = 90: , 50: ", "coverage", Threshold{Warning: "90:", Critical: "50:"}, false},
+		{"errors=@1k:2k,1.000.000", "errors", Threshold{Warning: "@1000:2000", Critical: "1000000"}, false},
+		{"inBits", "", Threshold{}, true},
+		{"inBits=5x", "", Threshold{}, true},
+		{"inBits=1,2:1", "", Threshold{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.definition, func(t *testing.T) {
+			name, th, err := Parse(tt.definition)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.definition, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName || th != tt.want {
+				t.Errorf("Parse(%q) = %q, %+v, want %q, %+v", tt.definition, name, th, tt.wantName, tt.want)
+			}
+		})
+	}
+}
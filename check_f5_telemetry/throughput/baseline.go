@@ -0,0 +1,200 @@
+package throughput
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// A week, the period of the baseline
+const week = 7 * 24 * time.Hour
+
+// The minimum number of historical samples needed to judge a deviation
+const minSamples = 10
+
+// The standard deviation used for the baseline is at least this fraction of
+// the mean (and at least 1), so a baseline without variance does not turn
+// every small difference into an infinite deviation
+const stdDevFloor = 0.01
+
+// The allowed deviation from the baseline, either in standard deviations or,
+// if Percent is set, in percent of the baseline
+type Deviation struct {
+	Value   float64
+	Percent bool
+}
+
+// The baseline of a metric calculated from the historical data
+type Baseline struct {
+	Mean   float64
+	StdDev float64
+	Count  float64
+}
+
+// Parse a deviation like "3" (standard deviations) or "50%" (percent of the
+// baseline). An empty string returns nil.
+func ParseDeviation(Value string) (*Deviation, error) {
+	d := strings.TrimSpace(Value)
+	if d == "" {
+		return nil, nil
+	}
+	result := new(Deviation)
+	if strings.HasSuffix(d, "%") {
+		result.Percent = true
+		d = strings.TrimSuffix(d, "%")
+	}
+	v, err := strconv.ParseFloat(d, 64)
+	if err != nil || v <= 0 {
+		return nil, errors.New("Invalid deviation " + Value + ", use a positive number of standard deviations or a percentage like 50%")
+	}
+	result.Value = v
+	return result, nil
+}
+
+// Human readable form of the deviation
+func (d *Deviation) String() string {
+	if d.Percent {
+		return strconv.FormatFloat(d.Value, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(d.Value, 'f', -1, 64) + " standard deviations"
+}
+
+// Check whether a value deviates from the baseline by more than allowed, in
+// either direction
+func (d *Deviation) exceeded(Value float64, b Baseline) bool {
+	if d == nil {
+		return false
+	}
+	if d.Percent {
+		return math.Abs(percentDeviation(Value, b)) > d.Value
+	}
+	return math.Abs(sigmaDeviation(Value, b)) > d.Value
+}
+
+// The deviation from the baseline in standard deviations. The standard
+// deviation is raised to the floor given by stdDevFloor.
+func sigmaDeviation(Value float64, b Baseline) float64 {
+	return (Value - b.Mean) / math.Max(b.StdDev, math.Max(stdDevFloor*math.Abs(b.Mean), 1))
+}
+
+// The deviation from the baseline in percent
+func percentDeviation(Value float64, b Baseline) float64 {
+	if b.Mean == 0 {
+		if Value == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (Value - b.Mean) / b.Mean * 100
+}
+
+// Build the baseline of the Metrics from the same time of the week over the
+// last Weeks weeks. For every week, the documents within Slot around the
+// current time of the week are used.
+func (t *Throughput) baseline(Weeks int, Slot time.Duration, Metrics []string) (map[string]Baseline, error) {
+	logger := log.With().Str("func", "baseline").Str("package", "throughput").Logger()
+	logger.Trace().Msg("Enter func")
+
+	half := int64(Slot.Seconds() / 2)
+	ranges := make([]string, 0, Weeks)
+	for i := 1; i <= Weeks; i++ {
+		offset := int64(i) * int64(week.Seconds())
+		ranges = append(ranges, fmt.Sprintf("{\"range\":{\"@timestamp\":{\"gte\":\"now-%ds\",\"lt\":\"now-%ds\"}}}", offset+half, offset-half))
+	}
+	aggs := make([]string, 0, len(Metrics))
+	for _, m := range Metrics {
+		aggs = append(aggs, fmt.Sprintf("\"%v\":{\"extended_stats\":{\"field\":\"system.throughputPerformance.%v.current\"}}", m, m))
+	}
+	slots := fmt.Sprintf("{\"bool\":{\"should\":[%v],\"minimum_should_match\":1}}", strings.Join(ranges, ","))
+	query := fmt.Sprintf("{\"size\":0,\"query\":%v,\"aggs\":{%v}}", t.connection.Filter(slots), strings.Join(aggs, ","))
+	data, err := t.connection.Search(t.index, query)
+	if err != nil {
+		reason := ""
+		if data != nil {
+			reason = data.Error.Reason
+		}
+		logger.Error().Str("id", "ERR20080001").
+			Str("query", query).
+			Str("reason", reason).
+			Err(err).
+			Msg("Could not run search")
+		t.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("%v. Could not run search on index %v. Query is %v", err, t.index, query))
+		return nil, err
+	}
+	result := make(map[string]Baseline)
+	for _, m := range Metrics {
+		stats := data.Aggregations[m]
+		b := Baseline{}
+		b.Count, _ = stats["count"].(float64)
+		b.Mean, _ = stats["avg"].(float64)
+		b.StdDev, _ = stats["std_deviation"].(float64)
+		logger.Debug().Str("id", "DBG20080001").
+			Str("metric", m).
+			Float64("count", b.Count).
+			Float64("mean", b.Mean).
+			Float64("stddev", b.StdDev).
+			Msg("Baseline calculated")
+		if b.Count == 0 {
+			continue
+		}
+		result[m] = b
+	}
+	return result, nil
+}
+
+// Compare the current values of the Metrics with their baseline from the last
+// Weeks weeks and alert if they deviate by more than Warn or Crit in either
+// direction. A sudden drop is treated like a spike.
+func (t *Throughput) CheckBaseline(Weeks int, Slot time.Duration, Metrics []string, Warn *Deviation, Crit *Deviation) {
+	logger := log.With().Str("func", "CheckBaseline").Str("package", "throughput").Logger()
+	logger.Trace().Msg("Enter func")
+
+	baselines, err := t.baseline(Weeks, Slot, Metrics)
+	if err != nil {
+		return
+	}
+	for _, m := range Metrics {
+		v, found := t.Fields[m]
+		if !found {
+			continue
+		}
+		b, found := baselines[m]
+		if !found {
+			logger.Error().Str("id", "ERR20090001").Str("metric", m).Msg("No historical data for the baseline")
+			t.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: no historical data for the baseline of %v in the last %v weeks", m, Weeks))
+			continue
+		}
+		if b.Count < minSamples {
+			logger.Error().Str("id", "ERR20090002").Str("metric", m).Float64("count", b.Count).Msg("Not enough historical data for the baseline")
+			t.nagios.AddResult(nagiosplugin.UNKNOWN, fmt.Sprintf("UNKNOWN: only %v samples for the baseline of %v in the last %v weeks, at least %v are needed", b.Count, m, Weeks, minSamples))
+			continue
+		}
+		direction := "above"
+		if v < b.Mean {
+			direction = "below"
+		}
+		msg := fmt.Sprintf("%v %v is %.1f%% (%.1f standard deviations) %v the baseline %v ± %v (%v weeks, %v)",
+			m, t.format(m), math.Abs(percentDeviation(v, b)), math.Abs(sigmaDeviation(v, b)), direction,
+			units.Format(b.Mean, MetricUnits[m][1]), units.Format(b.StdDev, MetricUnits[m][1]), Weeks, trend.FormatWindow(Slot))
+		if Crit.exceeded(v, b) {
+			t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v, more than %v", msg, Crit))
+		} else if Warn.exceeded(v, b) {
+			t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v, more than %v", msg, Warn))
+		} else {
+			t.nagios.AddResult(nagiosplugin.OK, "OK: "+msg)
+		}
+
+		p, _ := nagiosplugin.NewFloatPerfDatumValue(b.Mean)
+		t.nagios.AddPerfDatum(m+"_baseline", MetricUnits[m][0], p, nil, nil, nil, nil)
+		p, _ = nagiosplugin.NewFloatPerfDatumValue(b.StdDev)
+		t.nagios.AddPerfDatum(m+"_baseline_stddev", MetricUnits[m][0], p, nil, nil, nil, nil)
+	}
+}
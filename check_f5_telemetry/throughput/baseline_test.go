@@ -0,0 +1,63 @@
+package throughput
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSigmaDeviation(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    float64
+		baseline Baseline
+		want     float64
+	}{
+		{"within stddev", 110, Baseline{Mean: 100, StdDev: 20, Count: 20}, 0.5},
+		{"below", 60, Baseline{Mean: 100, StdDev: 20, Count: 20}, -2},
+		{"stddev 0 uses the floor", 1010, Baseline{Mean: 1000, StdDev: 0, Count: 20}, 1},
+		{"stddev 0 and mean 0", 3, Baseline{Mean: 0, StdDev: 0, Count: 20}, 3},
+		{"small stddev uses the floor", 1000100, Baseline{Mean: 1e6, StdDev: 1, Count: 20}, 0.01},
+		{"equal", 1000, Baseline{Mean: 1000, StdDev: 0, Count: 20}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sigmaDeviation(tt.value, tt.baseline)
+			if math.IsInf(got, 0) || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("sigmaDeviation(%v, %+v) = %v, want %v", tt.value, tt.baseline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeviation(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *Deviation
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"3", &Deviation{Value: 3}, false},
+		{"50%", &Deviation{Value: 50, Percent: true}, false},
+		{" 2.5 ", &Deviation{Value: 2.5}, false},
+		{"0", nil, true},
+		{"-1", nil, true},
+		{"x%", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDeviation(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDeviation(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("ParseDeviation(%q) = %+v, want nil", tt.value, got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.want {
+				t.Errorf("ParseDeviation(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
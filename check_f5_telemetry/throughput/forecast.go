@@ -0,0 +1,32 @@
+package throughput
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/forecast"
+)
+
+// The metrics forecasted if none are given
+var DefaultForecastMetrics = []string{"inBits", "outBits"}
+
+// Map the metric names to their fields for a forecast, see forecast.Forecast
+func ForecastMetrics(Names []string) (map[string]forecast.Metric, error) {
+	if len(Names) == 0 {
+		Names = DefaultForecastMetrics
+	}
+	result := make(map[string]forecast.Metric)
+	for _, name := range Names {
+		metric, ok := MetricName(strings.TrimSpace(name))
+		if !ok {
+			return nil, errors.New("Unknown forecast metric " + name)
+		}
+		result[metric] = forecast.Metric{
+			Field:    "system.throughputPerformance." + metric + ".current",
+			Scale:    1,
+			Unit:     MetricUnits[metric][1],
+			PerfUnit: MetricUnits[metric][0],
+		}
+	}
+	return result, nil
+}
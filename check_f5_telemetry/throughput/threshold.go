@@ -0,0 +1,26 @@
+package throughput
+
+import (
+	"strings"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+)
+
+// Find the metric in MetricFields, ignoring the case as viper converts all
+// keys in the configuration file to lower case
+func MetricName(Name string) (string, bool) {
+	for _, f := range MetricFields {
+		if strings.EqualFold(f, Name) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Merge the thresholds from the configuration file and the command line. The
+// global Warn and Crit ranges apply to inBits and outBits, unless they have
+// their own thresholds. Thresholds from the command line override those from
+// the configuration file.
+func MergeThresholds(Warn string, Crit string, Config map[string]threshold.Threshold, Definitions []string) (threshold.Thresholds, error) {
+	return threshold.Merge(Warn, Crit, []string{"inBits", "outBits"}, Config, Definitions, MetricName)
+}
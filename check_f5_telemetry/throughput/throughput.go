@@ -7,6 +7,8 @@ import (
 
 	//"github.com/davecgh/go-spew/spew"
 	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/threshold"
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/units"
 	"github.com/joernott/nagiosplugin/v2"
 	"github.com/rs/zerolog/log"
 )
@@ -26,6 +28,23 @@ var MetricFields = [...]string{
 	"inBits",
 	"outBits"}
 
+// Units of the metrics. The values are rates per second, the first unit is
+// used for the performance data, the second one for the text output.
+var MetricUnits = map[string][2]string{
+	"serverIn":      {"c", "pkt/s"},
+	"serverOut":     {"c", "pkt/s"},
+	"serverBitsIn":  {"b", "bit/s"},
+	"serverBitsOut": {"b", "bit/s"},
+	"clientIn":      {"c", "pkt/s"},
+	"clientOut":     {"c", "pkt/s"},
+	"clientBitsIn":  {"b", "bit/s"},
+	"clientBitsOut": {"b", "bit/s"},
+	"inPackets":     {"c", "pkt/s"},
+	"outPackets":    {"c", "pkt/s"},
+	"inBits":        {"b", "bit/s"},
+	"outBits":       {"b", "bit/s"},
+}
+
 // Where we store the metrics in a ThrougputData element
 type MetricData map[string]float64
 
@@ -36,6 +55,7 @@ type Throughput struct {
 	index      string
 	connection *elasticsearch.Elasticsearch
 	nagios     *nagiosplugin.Check
+	trend      string
 	Timestamp  time.Time  `yaml:"Timestamp" json:"Timestamp"`
 	Fields     MetricData `yaml:"Fields" json:"Fields"`
 }
@@ -59,7 +79,7 @@ func NewThroughput(Index string, Connection *elasticsearch.Elasticsearch, Nagios
 func (t *Throughput) Execute() error {
 	logger := log.With().Str("func", "Execute").Str("package", "throughput").Logger()
 	logger.Trace().Msg("Enter func")
-	query := "{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":{\"match_all\":{}},\"fields\":[\"@timestamp\",\"system.throughputPerformance.*.current\"],\"_source\":false}"
+	query := "{\"size\":1,\"sort\":{\"@timestamp\":\"desc\"},\"query\":" + t.connection.Filter("{\"match_all\":{}}") + ",\"fields\":[\"@timestamp\",\"system.throughputPerformance.*.current\"],\"_source\":false}"
 	data, err := t.connection.Search(t.index, query)
 	if err != nil {
 		reason := ""
@@ -81,6 +101,11 @@ func (t *Throughput) Execute() error {
 	return nil
 }
 
+// Read the metrics from the latest document, see Execute
+func (t *Throughput) Gather(e *elasticsearch.ElasticsearchResult) error {
+	return t.gatherThroughputData(e)
+}
+
 // Convert the Elasticsearch data into our data structure
 func (t *Throughput) gatherThroughputData(e *elasticsearch.ElasticsearchResult) error {
 	var fields elasticsearch.HitElement
@@ -140,59 +165,57 @@ func (t *Throughput) gatherThroughputData(e *elasticsearch.ElasticsearchResult)
 	return nil
 }
 
-// Chech whether we have reached any thesholds
-func (t *Throughput) Check(Warn string, Crit string, AgeWarn string, AgeCrit string) {
+// Chech whether we have reached any thesholds. Every metric with a threshold
+// is evaluated, see MergeThresholds.
+func (t *Throughput) Check(Thresholds threshold.Thresholds, AgeWarn string, AgeCrit string) {
 	logger := log.With().Str("func", "Check").Str("package", "throughput").Logger()
 	logger.Trace().Msg("Enter func")
 
 	ok := true
-	if checkRange(t.nagios, Crit, t.Fields["inBits"], "critical") {
-		t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: Bits In %v is above critical threshold %v", t.Fields["inBits"], Crit))
-		ok = false
-	}
-	if checkRange(t.nagios, Crit, t.Fields["outBits"], "critical") {
-		t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: Bits Out %v is above critical threshold %v", t.Fields["outBits"], Crit))
-		ok = false
-	}
-
-	if checkRange(t.nagios, Warn, t.Fields["inBits"], "warning") {
-		t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: Bits In %v is above warning threshold %v", t.Fields["inBits"], Warn))
-		ok = false
-	}
-	if checkRange(t.nagios, Warn, t.Fields["outBits"], "warning") {
-		t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: Bits Out %v is above warning threshold %v", t.Fields["outBits"], Warn))
-		ok = false
+	for _, f := range MetricFields {
+		th, found := Thresholds[f]
+		if !found {
+			continue
+		}
+		v, found := t.Fields[f]
+		if !found {
+			continue
+		}
+		logger.Debug().Str("id", "DBG20050001").
+			Str("field", f).
+			Float64("value", v).
+			Str("warning", th.Warning).
+			Str("critical", th.Critical).
+			Msg("Check threshold")
+		if threshold.CheckRange(t.nagios, th.Critical, v, "critical") {
+			t.nagios.AddResult(nagiosplugin.CRITICAL, fmt.Sprintf("CRITICAL: %v %v is outside the critical range %v", f, t.format(f), th.Critical))
+			ok = false
+		} else if threshold.CheckRange(t.nagios, th.Warning, v, "warning") {
+			t.nagios.AddResult(nagiosplugin.WARNING, fmt.Sprintf("WARNING: %v %v is outside the warning range %v", f, t.format(f), th.Warning))
+			ok = false
+		}
 	}
 
 	if ok {
-		t.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: Bits In %v and Out %v are within Thtesholds %v/%v", t.Fields["inBits"], t.Fields["outBits"], Warn, Crit))
+		t.nagios.AddResult(nagiosplugin.OK, fmt.Sprintf("OK: Bits In %v and Out %v are within thresholds", t.format("inBits"), t.format("outBits")))
 	}
-	t.checkAddPerfdata()
-}
-
-// check, if a value has reached the theshold
-func checkRange(nagios *nagiosplugin.Check, CheckRange string, Value float64, AlertType string) bool {
-	logger := log.With().Str("func", "checkRange").Str("package", "pool").Logger()
-	logger.Trace().Msg("Enter func")
-	if CheckRange == "" {
-		return false
-	}
-	r, err := nagiosplugin.ParseRange(CheckRange)
-	if err != nil {
-		logger.Error().Str("id", "ERR20060001").
-			Str("field", AlertType).
-			Str("range", CheckRange).
-			Err(err).
-			Msg("Error parsing range")
-		nagios.AddResult(nagiosplugin.UNKNOWN, "error parsing "+AlertType+" range "+CheckRange)
-	}
-	return r.Check(Value)
+	t.checkAddPerfdata(Thresholds)
 }
 
 // add performance data to the nagios output
-func (t *Throughput) checkAddPerfdata() {
+func (t *Throughput) checkAddPerfdata(Thresholds threshold.Thresholds) {
 	for _, f := range MetricFields {
+		th := Thresholds[f]
 		p, _ := nagiosplugin.NewFloatPerfDatumValue(t.Fields[f])
-		t.nagios.AddPerfDatum(f, "", p, nil, nil, nil, nil)
+		t.nagios.AddPerfDatum(f, MetricUnits[f][0], p, threshold.PerfRange(th.Warning), threshold.PerfRange(th.Critical), nil, nil)
+	}
+}
+
+// Human readable value of a metric, scaled to the appropriate SI prefix. For
+// trend checks, the aggregation is appended.
+func (t *Throughput) format(Field string) string {
+	if t.trend != "" {
+		return units.Format(t.Fields[Field], MetricUnits[Field][1]) + " (" + t.trend + ")"
 	}
+	return units.Format(t.Fields[Field], MetricUnits[Field][1])
 }
@@ -0,0 +1,37 @@
+package throughput
+
+import (
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/trend"
+	"github.com/joernott/nagiosplugin/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Execute an aggregation query over the last Window instead of reading the
+// latest document. The metrics are the result of the Aggregation (avg, min,
+// max or a percentile like p95), so a single spike does not raise an alert.
+func (t *Throughput) ExecuteTrend(Window time.Duration, Aggregation string) error {
+	logger := log.With().Str("func", "ExecuteTrend").Str("package", "throughput").Logger()
+	logger.Trace().Msg("Enter func")
+
+	fields := make(map[string]string)
+	for _, f := range MetricFields {
+		fields[f] = "system.throughputPerformance." + f + ".current"
+	}
+	t.Timestamp = time.Now()
+	values, err := trend.Execute(t.connection, t.index, Window, Aggregation, fields)
+	if err != nil {
+		logger.Error().Str("id", "ERR20070001").
+			Str("window", Window.String()).
+			Err(err).
+			Msg("Could not aggregate the window")
+		t.nagios.AddResult(nagiosplugin.UNKNOWN, err.Error())
+		return err
+	}
+	for f, value := range values {
+		t.Fields[f] = value
+	}
+	t.trend = trend.Describe(Aggregation, Window)
+	return nil
+}
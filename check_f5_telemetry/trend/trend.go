@@ -0,0 +1,143 @@
+// package trend builds aggregation queries over a time window and reads
+// their results, so checks can evaluate e.g. the average of a metric instead
+// of the latest sample
+package trend
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joernott/monitoring-check_f5_telemetry/check_f5_telemetry/elasticsearch"
+)
+
+// The supported aggregations. pNN is the NNth percentile.
+var Aggregations = [...]string{
+	"avg",
+	"min",
+	"max",
+	"p50",
+	"p90",
+	"p95",
+	"p99"}
+
+// Check whether the aggregation is supported
+func ValidateAggregation(Aggregation string) error {
+	for _, a := range Aggregations {
+		if a == Aggregation {
+			return nil
+		}
+	}
+	return errors.New("Unknown aggregation " + Aggregation + ", use one of " + strings.Join(Aggregations[:], ", "))
+}
+
+// The percentile of a pNN aggregation, ok is false for other aggregations
+func percentile(Aggregation string) (string, bool) {
+	if !strings.HasPrefix(Aggregation, "p") {
+		return "", false
+	}
+	return Aggregation[1:], true
+}
+
+// The range query selecting the documents of the last Window
+func RangeQuery(Window time.Duration) string {
+	return fmt.Sprintf("{\"range\":{\"@timestamp\":{\"gte\":\"now-%ds\"}}}", int64(Window.Seconds()))
+}
+
+// Build a query aggregating the Fields over the last Window, restricted to the
+// device of the Connection. Fields maps the names of the aggregations to the
+// fields in the documents.
+func Query(Connection *elasticsearch.Elasticsearch, Window time.Duration, Aggregation string, Fields map[string]string) string {
+	names := make([]string, 0, len(Fields))
+	for name := range Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	aggs := make([]string, 0, len(names))
+	for _, name := range names {
+		if p, ok := percentile(Aggregation); ok {
+			aggs = append(aggs, fmt.Sprintf("\"%v\":{\"percentiles\":{\"field\":\"%v\",\"percents\":[%v]}}", name, Fields[name], p))
+		} else {
+			aggs = append(aggs, fmt.Sprintf("\"%v\":{\"%v\":{\"field\":\"%v\"}}", name, Aggregation, Fields[name]))
+		}
+	}
+	return fmt.Sprintf("{\"size\":0,\"query\":%v,\"aggs\":{%v}}", Connection.Filter(RangeQuery(Window)), strings.Join(aggs, ","))
+}
+
+// Run the query built by Query on the Index and read the aggregated value of
+// every field. Fields without data in the window are missing in the result,
+// an error is returned if the search failed or no field had any data.
+func Execute(Connection *elasticsearch.Elasticsearch, Index string, Window time.Duration, Aggregation string, Fields map[string]string) (map[string]float64, error) {
+	query := Query(Connection, Window, Aggregation, Fields)
+	data, err := Connection.Search(Index, query)
+	if err != nil {
+		if data != nil && data.Error.Reason != "" {
+			err = fmt.Errorf("%v (%v)", err, data.Error.Reason)
+		}
+		return nil, fmt.Errorf("%v. Could not run search on index %v. Query is %v", err, Index, query)
+	}
+	values := make(map[string]float64)
+	for name := range Fields {
+		if value, ok := Value(data, Aggregation, name); ok {
+			values[name] = value
+		}
+	}
+	if len(values) == 0 {
+		return nil, errors.New("No data for the last " + FormatWindow(Window))
+	}
+	return values, nil
+}
+
+// Read the value of the aggregation Name from the result. ok is false if the
+// aggregation is missing or had no documents.
+func Value(e *elasticsearch.ElasticsearchResult, Aggregation string, Name string) (float64, bool) {
+	a, found := e.Aggregations[Name]
+	if !found {
+		return 0, false
+	}
+	if p, ok := percentile(Aggregation); ok {
+		values, _ := a["values"].(map[string]interface{})
+		for key, v := range values {
+			k, err := strconv.ParseFloat(key, 64)
+			if err != nil || strconv.FormatFloat(k, 'f', -1, 64) != p {
+				continue
+			}
+			f, ok := v.(float64)
+			return f, ok
+		}
+		return 0, false
+	}
+	f, ok := a["value"].(float64)
+	return f, ok
+}
+
+// Read the document count of a filter or bucket aggregation
+func DocCount(e *elasticsearch.ElasticsearchResult, Name string) float64 {
+	a, found := e.Aggregations[Name]
+	if !found {
+		return 0
+	}
+	f, _ := a["doc_count"].(float64)
+	return f
+}
+
+// Human readable description of the aggregation, e.g. "avg over 15m"
+func Describe(Aggregation string, Window time.Duration) string {
+	return Aggregation + " over " + FormatWindow(Window)
+}
+
+// Human readable window without trailing zero units, e.g. "15m" instead of
+// "15m0s"
+func FormatWindow(Window time.Duration) string {
+	w := Window.String()
+	if strings.HasSuffix(w, "m0s") {
+		w = strings.TrimSuffix(w, "0s")
+	}
+	if strings.HasSuffix(w, "h0m") {
+		w = strings.TrimSuffix(w, "0m")
+	}
+	return w
+}
@@ -0,0 +1,106 @@
+// package units formats values with SI prefixes and parses numbers and
+// ranges using them
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SI prefixes and their multipliers, from the largest to the smallest
+var prefixes = []struct {
+	prefix     string
+	multiplier float64
+}{
+	{"T", 1e12},
+	{"G", 1e9},
+	{"M", 1e6},
+	{"k", 1e3},
+}
+
+// Format a value with the largest fitting SI prefix and the given unit, e.g.
+// Format(1234567890, "bit/s") returns "1.23 Gbit/s"
+func Format(Value float64, Unit string) string {
+	for _, p := range prefixes {
+		if math.Abs(Value) >= p.multiplier {
+			return strconv.FormatFloat(Value/p.multiplier, 'f', 2, 64) + " " + p.prefix + Unit
+		}
+	}
+	return strconv.FormatFloat(Value, 'f', -1, 64) + " " + Unit
+}
+
+// Parse a number which may have a SI suffix (k, M, G, T, the k may also be
+// upper case), e.g. "20M" or "1.5G". Numbers using dots as thousands
+// separators like "20.000.000" are accepted as well.
+func ParseNumber(Number string) (float64, error) {
+	n := strings.TrimSpace(Number)
+	if n == "" {
+		return 0, errors.New("Empty number")
+	}
+	multiplier := float64(1)
+	for _, p := range prefixes {
+		if strings.HasSuffix(n, p.prefix) || (p.prefix == "k" && strings.HasSuffix(n, "K")) {
+			multiplier = p.multiplier
+			n = n[:len(n)-1]
+			break
+		}
+	}
+	if strings.Count(n, ".") > 1 {
+		n = strings.ReplaceAll(n, ".", "")
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number %v: %v", Number, err)
+	}
+	return v * multiplier, nil
+}
+
+// Expand the SI suffixes in a nagios range, e.g. "@1.5G:2G" becomes
+// "@1500000000:2000000000", so it can be parsed by nagiosplugin.ParseRange.
+// Empty ranges are returned unchanged.
+func ExpandRange(Range string) (string, error) {
+	r := strings.TrimSpace(Range)
+	if r == "" {
+		return r, nil
+	}
+	prefix := ""
+	if r[0] == '@' {
+		prefix = "@"
+		r = r[1:]
+	}
+	parts := strings.Split(r, ":")
+	if len(parts) > 2 {
+		return "", errors.New("Invalid range " + Range)
+	}
+	for i, part := range parts {
+		if part == "" || part == "~" {
+			continue
+		}
+		v, err := ParseNumber(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return prefix + strings.Join(parts, ":"), nil
+}
+
+// Parse a duration like time.ParseDuration, additionally accepting days and
+// weeks as a single unit, e.g. "14d" or "2w"
+func ParseDuration(Duration string) (time.Duration, error) {
+	d := strings.TrimSpace(Duration)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(d, suffix) {
+			v, err := strconv.ParseFloat(d[:len(d)-1], 64)
+			if err != nil {
+				return 0, fmt.Errorf("Invalid duration %v: %v", Duration, err)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(d)
+}
@@ -0,0 +1,113 @@
+package units
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		number  string
+		want    float64
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{" 1.5 ", 1.5, false},
+		{"20k", 20e3, false},
+		{"20K", 20e3, false},
+		{"1.5G", 1.5e9, false},
+		{"20M", 20e6, false},
+		{"2T", 2e12, false},
+		{"1.000.000", 1e6, false},
+		{"20.000.000", 20e6, false},
+		{"-5", -5, false},
+		{"", 0, true},
+		{"M", 0, true},
+		{"5x", 0, true},
+		{"1,5", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			got, err := ParseNumber(tt.number)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNumber(%q) error = %v, wantErr %v", tt.number, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseNumber(%q) = %v, want %v", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"10", "10", false},
+		{"800M", "800000000", false},
+		{"@1.5G:2G", "@1500000000:2000000000", false},
+		{"10k:", "10000:", false},
+		{"~:1k", "~:1000", false},
+		{"1.000.000:", "1000000:", false},
+		{"1:2:3", "", true},
+		{"1x:2", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ExpandRange(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandRange(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"90s", 90 * time.Second, false},
+		{"15m", 15 * time.Minute, false},
+		{"14d", 14 * 24 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"xd", 0, true},
+		{"5x", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDuration(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		value float64
+		unit  string
+		want  string
+	}{
+		{1234567890, "bit/s", "1.23 Gbit/s"},
+		{1500, "pkt/s", "1.50 kpkt/s"},
+		{12, "bit/s", "12 bit/s"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.value, tt.unit); got != tt.want {
+			t.Errorf("Format(%v, %q) = %q, want %q", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}